@@ -0,0 +1,115 @@
+// Command reencrypt encrypts plaintext todo details and rewraps details encrypted with
+// retired key versions after a key rotation. It is meant to run as a Container Apps job
+// using the same image and configuration as the server. With reencrypt.decrypt set, it
+// decrypts all details instead, which is required before migrating below version 5.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"log/slog"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/config"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/envelope"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/postgres"
+)
+
+func main() {
+	cfg, printOnly, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if printOnly {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cfg.Redacted()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(run(cfg))
+}
+
+func run(cfg config.Config) int {
+	slog.SetDefault(slog.New(log.NewContextHandler(log.NewStructured(os.Stderr, cfg.Debug, cfg.Log.RedactKeys...))))
+
+	if cfg.Database.KeyringFile == "" {
+		slog.Error("database.keyringFile must be set")
+		return 1
+	}
+	keyring, err := envelope.LoadKeyring(cfg.Database.KeyringFile)
+	if err != nil {
+		slog.Error("loading keyring", log.ErrorKey, err)
+		return 1
+	}
+	storeOpts := []postgres.Option{postgres.WithEncryption(envelope.New(keyring))}
+	if cfg.Database.StatementTimeout > 0 {
+		storeOpts = append(storeOpts, postgres.WithStatementTimeout(cfg.Database.StatementTimeout))
+	}
+	if cfg.Token.Provider != "" || cfg.Token.Scope != "" {
+		tokens, err := postgres.NewTokenProvider(postgres.TokenConfig{
+			Provider:  cfg.Token.Provider,
+			Scope:     cfg.Token.Scope,
+			ClientID:  cfg.Token.ClientID,
+			TenantID:  cfg.Token.TenantID,
+			TokenFile: cfg.Token.TokenFile,
+			Token:     cfg.Token.Token,
+		})
+		if err != nil {
+			slog.Error("configuring token provider", log.ErrorKey, err)
+			return 1
+		}
+		storeOpts = append(storeOpts, postgres.WithTokenProvider(tokens))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	startupCtx, cancel := context.WithTimeout(ctx, cfg.StartupTimeout)
+	defer cancel()
+	store, err := postgres.NewStore(startupCtx, cfg.Database.ConnString, storeOpts...)
+	if err != nil {
+		slog.Error("initializing data store", log.ErrorKey, err)
+		return 1
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if err := store.Close(ctx); err != nil {
+			slog.Warn("closing data store", log.ErrorKey, err)
+		}
+	}()
+
+	start := time.Now()
+	if cfg.Reencrypt.Decrypt {
+		n, err := store.Decrypt(ctx, cfg.Reencrypt.BatchSize)
+		if err != nil {
+			slog.Error("decrypting todos", slog.Int("updated", n), log.ErrorKey, err)
+			return 1
+		}
+		slog.Info("decrypted todos", slog.Int("updated", n), slog.Duration("duration", time.Since(start)))
+		return 0
+	}
+	n, err := store.Reencrypt(ctx, cfg.Reencrypt.BatchSize)
+	if err != nil {
+		slog.Error("re-encrypting todos", slog.Int("updated", n), log.ErrorKey, err)
+		return 1
+	}
+	slog.Info("re-encrypted todos", slog.Int("updated", n), slog.Duration("duration", time.Since(start)))
+	return 0
+}
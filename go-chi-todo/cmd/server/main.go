@@ -2,7 +2,13 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -13,62 +19,286 @@ import (
 
 	"log/slog"
 
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/admin"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/breaker"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/certs"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/config"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/cors"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/drain"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/envelope"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/health"
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/postgres"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/ratelimit"
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/router"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
+// exitMargin is kept from the termination grace period to flush telemetry and exit.
+const exitMargin = 2 * time.Second
+
 func main() {
-	debug := false
-	debugEnv := strings.ToLower(os.Getenv("TODO_DEBUG"))
-	if debugEnv == "yes" || debugEnv == "true" || debugEnv == "on" || debugEnv == "1" {
-		debug = true
+	cfg, printOnly, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+	if printOnly {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(cfg.Redacted()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
 	}
 
-	connString := os.Getenv("TODO_CONN_STRING")
-	listenAddr := os.Getenv("TODO_LISTEN_ADDR")
+	os.Exit(run(cfg))
+}
 
-	os.Exit(run(listenAddr, connString, debug))
+// clientAuthType maps tls.clientAuth to the TLS client authentication policy. Note that
+// Container Apps health probes don't present client certificates, so "require" is only suitable
+// if probes are disabled or use TCP.
+func clientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(mode) {
+	case "", "require":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional", "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode %q", mode)
+	}
 }
 
-func run(listenAddr string, connString string, debug bool) int {
-	slog.SetDefault(slog.New(log.NewStructured(os.Stderr, debug)))
+func run(cfg config.Config) int {
+	slog.SetDefault(slog.New(log.NewContextHandler(log.NewStructured(os.Stderr, cfg.Debug, cfg.Log.RedactKeys...))))
 
-	if connString == "" {
+	if cfg.Database.ConnString == "" {
 		slog.Info("no connection string specified, using pqlib style PG* environment variables instead")
 	}
 
-	if listenAddr == "" {
-		listenAddr = ":8080"
+	var corsPolicy *cors.Policy
+	if len(cfg.CORS.AllowedOrigins) > 0 {
+		p, err := cors.New(cors.Config{
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   cfg.CORS.AllowedMethods,
+			AllowedHeaders:   cfg.CORS.AllowedHeaders,
+			ExposedHeaders:   cfg.CORS.ExposedHeaders,
+			AllowCredentials: cfg.CORS.AllowCredentials,
+			MaxAge:           cfg.CORS.MaxAge,
+		})
+		if err != nil {
+			slog.Error("configuring CORS", log.ErrorKey, err)
+			return 1
+		}
+		corsPolicy = p
+	}
+
+	var reloader *certs.Reloader
+	var tlsConfig *tls.Config
+	if cfg.TLS.CertFile != "" {
+		clientAuth, err := clientAuthType(cfg.TLS.ClientAuth)
+		if err != nil {
+			slog.Error("configuring TLS", log.ErrorKey, err)
+			return 1
+		}
+		reloader, err = certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			slog.Error("loading TLS certificate", log.ErrorKey, err)
+			return 1
+		}
+		tlsConfig = reloader.TLSConfig(clientAuth)
+	}
+
+	storeOpts := []postgres.Option{postgres.WithPoolSize(int32(cfg.Database.MinConns), int32(cfg.Database.MaxConns))}
+	if cfg.Database.KeyringFile != "" {
+		keyring, err := envelope.LoadKeyring(cfg.Database.KeyringFile)
+		if err != nil {
+			slog.Error("loading encryption keyring", log.ErrorKey, err)
+			return 1
+		}
+		storeOpts = append(storeOpts, postgres.WithEncryption(envelope.New(keyring)))
+	}
+	if cfg.Database.SlowQuery > 0 {
+		storeOpts = append(storeOpts, postgres.WithSlowQueryThreshold(cfg.Database.SlowQuery))
+	}
+	if cfg.Database.StatementTimeout > 0 {
+		storeOpts = append(storeOpts, postgres.WithStatementTimeout(cfg.Database.StatementTimeout))
+	}
+	if cfg.Database.ReplicaConn != "" {
+		storeOpts = append(storeOpts, postgres.WithReplica(cfg.Database.ReplicaConn, cfg.Database.ReplicaMaxLag))
+	}
+	if cfg.Token.Provider != "" || cfg.Token.Scope != "" {
+		tokens, err := postgres.NewTokenProvider(postgres.TokenConfig{
+			Provider:  cfg.Token.Provider,
+			Scope:     cfg.Token.Scope,
+			ClientID:  cfg.Token.ClientID,
+			TenantID:  cfg.Token.TenantID,
+			TokenFile: cfg.Token.TokenFile,
+			Token:     cfg.Token.Token,
+		})
+		if err != nil {
+			slog.Error("configuring token provider", log.ErrorKey, err)
+			return 1
+		}
+		storeOpts = append(storeOpts, postgres.WithTokenProvider(tokens))
+	}
+
+	shutdownTelemetry, err := telemetry.Setup(context.Background())
+	if err != nil {
+		slog.Error("configuring OpenTelemetry", log.ErrorKey, err)
+		return 1
 	}
+	// killAt is when the process gets killed after a signal started the shutdown.
+	var killAt time.Time
+	defer func() {
+		deadline := time.Now().Add(5 * time.Second)
+		if !killAt.IsZero() && killAt.Add(-exitMargin/2).Before(deadline) {
+			deadline = killAt.Add(-exitMargin / 2)
+		}
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		if err := shutdownTelemetry(ctx); err != nil {
+			slog.Warn("flushing telemetry", log.ErrorKey, err)
+		}
+	}()
 
-	startupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	startupCtx, cancel := context.WithTimeout(context.Background(), cfg.StartupTimeout)
 	defer cancel()
-	store, err := postgres.NewStore(startupCtx, connString)
+	store, err := postgres.NewStore(startupCtx, cfg.Database.ConnString, storeOpts...)
 	if err != nil {
 		slog.Error("initializing data store", log.ErrorKey, err)
 		return 1
 	}
 
-	r := router.NewMux(store)
+	bgCtx, stopBg := context.WithCancel(context.Background())
+	defer stopBg()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		store.Collector(),
+	)
+
+	drainer := &drain.Drainer{}
+	checks := health.New()
+	store.RegisterChecks(checks)
+
+	var todos model.TodoStore = store
+	var collaborators model.CollaboratorStore = store
+	var lists model.ListStore = store
+	var idempotency model.IdempotencyStore = store
+	opts := []router.Option{
+		router.WithMetrics(reg),
+		router.WithHealth(checks),
+		router.WithDrain(drainer),
+		router.WithAccessLog(cfg.Log.AccessSampleRate, cfg.Log.AccessSlow),
+		router.WithTrustedProxyHops(cfg.TrustedProxyHops),
+		router.WithAPIKeys(cfg.Auth.APIKeys),
+		router.WithDBTimeouts(cfg.Database.Timeout, cfg.Database.RouteTimeouts),
+	}
+	if cfg.Breaker.Threshold > 0 || cfg.ReadOnly {
+		// Disabling the breaker uses a threshold that is never reached, so read-only mode still works.
+		threshold := cfg.Breaker.Threshold
+		if threshold <= 0 {
+			threshold = math.MaxInt
+		}
+		b := breaker.NewStore(store, breaker.New(threshold, cfg.Breaker.OpenTimeout), breaker.New(threshold, cfg.Breaker.OpenTimeout))
+		b.SetReadOnly(cfg.ReadOnly)
+		todos, collaborators, lists, idempotency = b, b.WrapCollaborators(store), b.WrapLists(store), b.WrapIdempotency(store)
+		opts = append(opts, router.WithBreaker(b))
+	}
+	if cfg.Auth.TrustEasyAuth {
+		opts = append(opts, router.WithEasyAuth())
+	}
+	if cfg.PublicMetrics {
+		opts = append(opts, router.WithPublicMetrics())
+	}
+	if cfg.Auth.AllowAnonymous {
+		opts = append(opts, router.WithAnonymous())
+	}
+	opts = append(opts, router.WithCollaborators(collaborators), router.WithLists(lists), router.WithIdempotency(idempotency, cfg.IdempotencyTTL))
+	readLimit := ratelimit.Limit{Rate: cfg.RateLimit.Read.RPS, Burst: cfg.RateLimit.Read.Burst}
+	writeLimit := ratelimit.Limit{Rate: cfg.RateLimit.Write.RPS, Burst: cfg.RateLimit.Write.Burst}
+	if readLimit.Enabled() || writeLimit.Enabled() {
+		opts = append(opts, router.WithRateLimit(ratelimit.NewMemory(10*time.Minute), readLimit, writeLimit))
+	}
+	if corsPolicy != nil {
+		opts = append(opts, router.WithCORS(corsPolicy))
+	}
+	go purgeIdempotencyKeys(bgCtx, store, time.Hour)
+	go store.RefreshTokens(bgCtx)
+	go store.MonitorReplica(bgCtx, 10*time.Second)
+	if reloader != nil {
+		go reloader.Watch(bgCtx, cfg.TLS.ReloadInterval)
+	}
+
+	r := router.NewMux(todos, opts...)
 	s := http.Server{
-		Addr:              listenAddr,
+		Addr:              cfg.ListenAddr,
 		Handler:           r,
-		ReadHeaderTimeout: 5 * time.Second,
-		WriteTimeout:      5 * time.Second,
-		IdleTimeout:       120 * time.Second,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		TLSConfig:         tlsConfig,
+	}
+
+	var adminServer *http.Server
+	if cfg.Admin.Addr != "" {
+		// Listen before serving, so a port conflict fails startup instead of going unnoticed.
+		ln, err := net.Listen("tcp", cfg.Admin.Addr)
+		if err != nil {
+			slog.Error("starting admin server", log.ErrorKey, err)
+			return 1
+		}
+		redacted := cfg.Redacted()
+		adminServer = &http.Server{
+			Handler: admin.NewMux(
+				admin.WithMetrics(reg),
+				admin.WithToken(cfg.Admin.Token),
+				admin.WithLogLevels(log.DefaultLevels(), cfg.Log.LevelRevert),
+				admin.WithSection("pool", func() any { return store.PoolStats() }),
+				admin.WithSection("queries", func() any { return store.QueryStats() }),
+				admin.WithSection("config", func() any { return redacted }),
+				admin.WithSection("health", func() any { return checks.Run(context.Background()) }),
+			),
+			// No WriteTimeout, since CPU profiles and traces stream for as long as requested.
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		}
+		slog.Info("starting admin server", slog.String("addr", ln.Addr().String()))
+		go func() {
+			if err := adminServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("admin server error", log.ErrorKey, err)
+			}
+		}()
 	}
 
 	errC := make(chan error, 1)
-	slog.Info("starting server", slog.String("addr", listenAddr))
+	slog.Info("starting server", slog.String("addr", cfg.ListenAddr), slog.Bool("tls", tlsConfig != nil), slog.Bool("mtls", cfg.TLS.ClientCAFile != ""), slog.Bool("tracing", telemetry.Enabled()))
 	slog.Info("configured CPU limit", "GOMAXPROCS", runtime.GOMAXPROCS(0))
 	go func() {
 		defer close(errC)
-		if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if tlsConfig != nil {
+			// Certificates are served by TLSConfig.GetCertificate.
+			err = s.ListenAndServeTLS("", "")
+		} else {
+			err = s.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errC <- err
 		}
 	}()
 
+	go toggleDebugOnHangup(bgCtx, cfg.Log.LevelRevert)
+
 	sigC := make(chan os.Signal, 1)
 	signal.Notify(sigC, syscall.SIGINT, syscall.SIGTERM)
 
@@ -82,22 +312,93 @@ func run(listenAddr string, connString string, debug bool) int {
 	case sig := <-sigC:
 		signal.Stop(sigC)
 		slog.Warn("received signal", slog.String("signal", sig.String()))
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		killAt = time.Now().Add(cfg.TerminationGracePeriod)
+
+		// Fail readiness first and give the ingress time to stop routing requests to this
+		// replica, otherwise requests arriving during shutdown are refused.
+		drainer.Start()
+		before := drainer.Stats()
+		slog.Info("draining", slog.Duration("delay", cfg.DrainDelay), slog.Int64("inFlight", before.InFlight))
+		time.Sleep(cfg.DrainDelay)
+		after := drainer.Stats()
+		slog.Info("drain delay elapsed", slog.Int64("completed", after.Completed-before.Completed), slog.Int64("inFlight", after.InFlight))
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
 		defer cancel()
 		slog.Info("waiting for shutdown to complete")
-		if err := s.Shutdown(ctx); err != nil {
-			slog.Error("shutting down", log.ErrorKey, err)
+		err := s.Shutdown(ctx)
+		final := drainer.Stats()
+		drained := slog.Int64("drained", final.Completed-after.Completed)
+		if err != nil {
+			slog.Error("shutting down", log.ErrorKey, err, drained, slog.Int64("aborted", final.InFlight))
+			s.Close()
+		} else {
+			slog.Info("server shut down", drained)
+		}
+		if adminServer != nil {
+			if err := adminServer.Shutdown(ctx); err != nil {
+				slog.Error("shutting down admin server", log.ErrorKey, err)
+			}
 		}
 		slog.Info("shutdown complete")
 	}
 
-	slog.Info("disconnecting from database")
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	// Stop background jobs, so they don't hold on to connections.
+	stopBg()
+	busy := store.PoolStats()["primary"].AcquiredConns
+	slog.Info("disconnecting from database", slog.Int("acquiredConns", int(busy)))
+	// Closing the pool gets what is left of the grace period, except for exitMargin.
+	closeBy := time.Now().Add(cfg.ShutdownTimeout)
+	if !killAt.IsZero() {
+		closeBy = killAt.Add(-exitMargin)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), closeBy)
 	defer cancel()
 	if err := store.Close(ctx); err != nil {
-		slog.Warn("closing data store", log.ErrorKey, err)
+		slog.Warn("closing data store", log.ErrorKey, err, slog.Int("aborted", int(store.PoolStats()["primary"].AcquiredConns)))
 	}
 
 	slog.Info("exiting")
 	return 0
 }
+
+// toggleDebugOnHangup switches the base log level to debug on SIGHUP, and back on the next
+// SIGHUP or after revert.
+func toggleDebugOnHangup(ctx context.Context, revert time.Duration) {
+	hupC := make(chan os.Signal, 1)
+	signal.Notify(hupC, syscall.SIGHUP)
+	defer signal.Stop(hupC)
+	levels := log.DefaultLevels()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hupC:
+			if levels.Level("") > slog.LevelDebug {
+				levels.Set("", slog.LevelDebug, revert)
+				slog.Warn("enabled debug logging", slog.Duration("revertAfter", revert))
+			} else {
+				levels.Reset("")
+				slog.Warn("reset log level", slog.String("level", levels.Level("").String()))
+			}
+		}
+	}
+}
+
+func purgeIdempotencyKeys(ctx context.Context, store *postgres.TodoStore, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			n, err := store.PurgeIdempotencyKeys(ctx)
+			if err != nil {
+				slog.Warn("purging expired idempotency keys", log.ErrorKey, err)
+				continue
+			}
+			slog.Debug("purged expired idempotency keys", slog.Int64("count", n))
+		}
+	}
+}
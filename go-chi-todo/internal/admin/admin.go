@@ -0,0 +1,181 @@
+// Package admin implements the diagnostics endpoints served on the admin listener. The admin
+// listener must only be reachable from inside the container or pod, never through ingress.
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+// proxyHeaders are added by Container Apps ingress and other reverse proxies.
+var proxyHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Envoy-External-Address"}
+
+func NewMux(opts ...Option) http.Handler {
+	o := options{sections: make(map[string]func() any)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	r := chi.NewRouter()
+	r.Use(direct)
+	r.HandleFunc("/debug/pprof/*", pprof.Index)
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.Get("/debug/build", section(buildInfo))
+	r.Get("/debug/runtime", section(runtimeInfo))
+	for name, fn := range o.sections {
+		r.Get("/debug/"+name, section(fn))
+	}
+	if o.levels != nil {
+		r.Get("/debug/loglevel", levelsHandler(o.levels))
+		if o.token != "" {
+			r.With(authorized(o.token)).Put("/debug/loglevel", setLevelHandler(o.levels, o.revert))
+			r.With(authorized(o.token)).Delete("/debug/loglevel", resetLevelHandler(o.levels))
+		}
+	}
+	if o.metrics != nil {
+		r.Method(http.MethodGet, "/metrics", promhttp.HandlerFor(o.metrics, promhttp.HandlerOpts{}))
+	}
+	return r
+}
+
+// direct rejects requests that were forwarded by a proxy, in case the admin port is
+// accidentally exposed through ingress.
+func direct(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range proxyHeaders {
+			if r.Header.Get(h) != "" {
+				slog.WarnContext(r.Context(), "rejecting proxied admin request", slog.String("path", r.URL.Path), slog.String("header", h))
+				http.NotFound(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func section(fn func() any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(fn()); err != nil {
+			slog.WarnContext(r.Context(), "writing admin response", slog.String("path", r.URL.Path), log.ErrorKey, err)
+		}
+	}
+}
+
+type build struct {
+	GoVersion string            `json:"goVersion"`
+	Path      string            `json:"path"`
+	Version   string            `json:"version"`
+	Settings  map[string]string `json:"settings,omitempty"`
+}
+
+func buildInfo() any {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build{GoVersion: runtime.Version()}
+	}
+	b := build{
+		GoVersion: bi.GoVersion,
+		Path:      bi.Main.Path,
+		Version:   bi.Main.Version,
+		Settings:  make(map[string]string),
+	}
+	for _, s := range bi.Settings {
+		if strings.HasPrefix(s.Key, "vcs") || s.Key == "GOOS" || s.Key == "GOARCH" || s.Key == "CGO_ENABLED" {
+			b.Settings[s.Key] = s.Value
+		}
+	}
+	return b
+}
+
+type heap struct {
+	Alloc      uint64        `json:"alloc"`
+	Inuse      uint64        `json:"inuse"`
+	Idle       uint64        `json:"idle"`
+	Released   uint64        `json:"released"`
+	Objects    uint64        `json:"objects"`
+	Sys        uint64        `json:"sys"`
+	NextGC     uint64        `json:"nextGC"`
+	NumGC      uint32        `json:"numGC"`
+	PauseTotal time.Duration `json:"pauseTotal"`
+	LastGC     time.Time     `json:"lastGC,omitzero"`
+}
+
+type runtimeSummary struct {
+	GOMAXPROCS int            `json:"gomaxprocs"`
+	NumCPU     int            `json:"numCPU"`
+	Goroutines int            `json:"goroutines"`
+	ByFunction map[string]int `json:"goroutinesByFunction"`
+	Heap       heap           `json:"heap"`
+}
+
+func runtimeInfo() any {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	s := runtimeSummary{
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		NumCPU:     runtime.NumCPU(),
+		ByFunction: goroutines(),
+		Heap: heap{
+			Alloc:      ms.HeapAlloc,
+			Inuse:      ms.HeapInuse,
+			Idle:       ms.HeapIdle,
+			Released:   ms.HeapReleased,
+			Objects:    ms.HeapObjects,
+			Sys:        ms.HeapSys,
+			NextGC:     ms.NextGC,
+			NumGC:      ms.NumGC,
+			PauseTotal: time.Duration(ms.PauseTotalNs),
+		},
+	}
+	if ms.LastGC > 0 {
+		s.Heap.LastGC = time.Unix(0, int64(ms.LastGC)).UTC()
+	}
+	for _, n := range s.ByFunction {
+		s.Goroutines += n
+	}
+	return s
+}
+
+// goroutines counts goroutines by the first function on their stack that is not part of the
+// runtime, which is usually where they are blocked. The full stacks are available at
+// /debug/pprof/goroutine?debug=1.
+func goroutines() map[string]int {
+	var records []runtime.StackRecord
+	n := runtime.NumGoroutine()
+	for {
+		records = make([]runtime.StackRecord, n+10)
+		var ok bool
+		if n, ok = runtime.GoroutineProfile(records); ok {
+			records = records[:n]
+			break
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, rec := range records {
+		frames := runtime.CallersFrames(rec.Stack())
+		f, more := frames.Next()
+		for more && strings.HasPrefix(f.Function, "runtime.") {
+			f, more = frames.Next()
+		}
+		counts[f.Function]++
+	}
+	return counts
+}
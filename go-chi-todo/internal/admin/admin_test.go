@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+func TestNewMux(t *testing.T) {
+	mux := NewMux(WithSection("pool", func() any {
+		return map[string]int{"acquiredConns": 1}
+	}))
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		want   int
+	}{
+		{"pprof", "/debug/pprof/", "", http.StatusOK},
+		{"build", "/debug/build", "", http.StatusOK},
+		{"runtime", "/debug/runtime", "", http.StatusOK},
+		{"section", "/debug/pool", "", http.StatusOK},
+		{"unknown section", "/debug/config", "", http.StatusNotFound},
+		{"forwarded", "/debug/pool", "X-Forwarded-For", http.StatusNotFound},
+		{"envoy", "/debug/pprof/", "X-Envoy-External-Address", http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.header != "" {
+				r.Header.Set(tc.header, "203.0.113.7")
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("Want status code %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestRuntimeInfo(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewMux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/runtime", nil))
+
+	var got runtimeSummary
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if got.Goroutines < 1 {
+		t.Errorf("Want at least 1 goroutine, got %d", got.Goroutines)
+	}
+	if got.ByFunction["testing.(*T).Run"] < 1 {
+		t.Errorf("Want goroutine blocked in testing.(*T).Run, got %v", got.ByFunction)
+	}
+	if got.Heap.Alloc == 0 {
+		t.Error("Want heap allocation > 0, got 0")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	levels := &log.Levels{}
+	mux := NewMux(WithToken("secret"), WithLogLevels(levels, time.Minute))
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		body   string
+		want   int
+		level  slog.Level
+	}{
+		{"unauthenticated", http.MethodPut, "", `{"component":"router","level":"debug"}`, http.StatusUnauthorized, slog.LevelInfo},
+		{"wrong_token", http.MethodPut, "wrong", `{"component":"router","level":"debug"}`, http.StatusUnauthorized, slog.LevelInfo},
+		{"invalid_level", http.MethodPut, "secret", `{"component":"router","level":"verbose"}`, http.StatusBadRequest, slog.LevelInfo},
+		{"set", http.MethodPut, "secret", `{"component":"router","level":"debug","duration":"5m"}`, http.StatusOK, slog.LevelDebug},
+		{"reset", http.MethodDelete, "secret", "", http.StatusOK, slog.LevelInfo},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			target := "/debug/loglevel"
+			if tc.method == http.MethodDelete {
+				target += "?component=router"
+			}
+			r := httptest.NewRequest(tc.method, target, strings.NewReader(tc.body))
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("Want status code %d, got %d", tc.want, w.Code)
+			}
+			if got := levels.Level("router"); got != tc.level {
+				t.Errorf("Want router level %v, got %v", tc.level, got)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	NewMux(WithLogLevels(levels, time.Minute)).ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/debug/loglevel", strings.NewReader(`{"level":"debug"}`)))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Want status code %d without a token, got %d", http.StatusMethodNotAllowed, w.Code)
+	}
+}
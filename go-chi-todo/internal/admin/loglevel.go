@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+type levelsResponse struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+type levelRequest struct {
+	// Component is a package name such as "router" or "postgres". Empty sets the base level.
+	Component string `json:"component"`
+	Level     string `json:"level"`
+	// Duration after which the change is reverted, such as "15m".
+	Duration string `json:"duration"`
+}
+
+func levelsHandler(levels *log.Levels) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := levelsResponse{Components: make(map[string]string)}
+		for component, level := range levels.Snapshot() {
+			if component == "" {
+				res.Level = level.String()
+				continue
+			}
+			res.Components[component] = level.String()
+		}
+		section(func() any { return res })(w, r)
+	}
+}
+
+func setLevelHandler(levels *log.Levels, defaultRevert time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req levelRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		level, err := log.ParseLevel(req.Level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		revert := defaultRevert
+		if req.Duration != "" {
+			if revert, err = time.ParseDuration(req.Duration); err != nil || revert < 0 {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+		}
+		levels.Set(req.Component, level, revert)
+		slog.WarnContext(r.Context(), "changed log level", slog.String("component", req.Component), slog.String("level", level.String()), slog.Duration("revertAfter", revert))
+		levelsHandler(levels)(w, r)
+	}
+}
+
+func resetLevelHandler(levels *log.Levels) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		component := r.URL.Query().Get("component")
+		levels.Reset(component)
+		slog.WarnContext(r.Context(), "reset log level", slog.String("component", component))
+		levelsHandler(levels)(w, r)
+	}
+}
+
+// authorized requires the admin token as a bearer token.
+func authorized(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+type Option func(*options)
+
+type options struct {
+	sections map[string]func() any
+	metrics  *prometheus.Registry
+	token    string
+	levels   *log.Levels
+	revert   time.Duration
+}
+
+// WithSection serves the JSON encoding of fn's result at /debug/<name>. fn is called on
+// every request and must return data that is safe to expose to operators.
+func WithSection(name string, fn func() any) Option {
+	return func(o *options) {
+		o.sections[name] = fn
+	}
+}
+
+// WithMetrics serves all metrics in reg at /metrics.
+func WithMetrics(reg *prometheus.Registry) Option {
+	return func(o *options) {
+		o.metrics = reg
+	}
+}
+
+// WithToken requires token as a bearer token for endpoints that change state. Without a
+// token, these endpoints aren't served.
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithLogLevels serves levels at /debug/loglevel. Changes are reverted after revert unless the
+// request asks for a different duration.
+func WithLogLevels(levels *log.Levels, revert time.Duration) Option {
+	return func(o *options) {
+		o.levels = levels
+		o.revert = revert
+	}
+}
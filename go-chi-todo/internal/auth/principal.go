@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Headers injected by the Container Apps built-in authentication (Easy Auth). The platform
+// strips these from client requests only when Easy Auth is enabled, so FromRequest must not
+// be used unless the app is configured to trust them.
+const (
+	PrincipalIDHeader       = "X-MS-CLIENT-PRINCIPAL-ID"
+	PrincipalNameHeader     = "X-MS-CLIENT-PRINCIPAL-NAME"
+	PrincipalProviderHeader = "X-MS-CLIENT-PRINCIPAL-IDP"
+)
+
+type Principal struct {
+	ID       string
+	Name     string
+	Provider string
+}
+
+type principalKey struct{}
+
+func FromRequest(r *http.Request) (Principal, bool) {
+	id := strings.TrimSpace(r.Header.Get(PrincipalIDHeader))
+	if id == "" {
+		return Principal{}, false
+	}
+	p := Principal{
+		ID:       id,
+		Name:     r.Header.Get(PrincipalNameHeader),
+		Provider: r.Header.Get(PrincipalProviderHeader),
+	}
+	return p, true
+}
+
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+type subjectKey struct{}
+
+// NewSubjectContext stores the subject of a verified TLS client certificate.
+func NewSubjectContext(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, subject)
+}
+
+func SubjectFromContext(ctx context.Context) (string, bool) {
+	s, ok := ctx.Value(subjectKey{}).(string)
+	return s, ok
+}
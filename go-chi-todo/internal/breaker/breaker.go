@@ -0,0 +1,106 @@
+package breaker
+
+import (
+	"sync"
+	"time"
+)
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// probeRetryAfter is suggested to callers rejected while a half-open breaker waits for its probe.
+const probeRetryAfter = time.Second
+
+// Breaker opens after threshold consecutive failures and rejects calls for openTimeout. It then
+// lets a single probe through: if the probe succeeds, the breaker closes, otherwise it opens again.
+type Breaker struct {
+	mutex       sync.Mutex
+	threshold   int
+	openTimeout time.Duration
+	state       State
+	failures    int
+	openedAt    time.Time
+	probing     bool
+	now         func() time.Time
+}
+
+func New(threshold int, openTimeout time.Duration) *Breaker {
+	return &Breaker{threshold: max(threshold, 1), openTimeout: openTimeout, now: time.Now}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be followed by Success,
+// Failure or Release. Rejected calls should be retried after retryAfter.
+func (b *Breaker) Allow() (retryAfter time.Duration, ok bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	switch b.state {
+	case Open:
+		if wait := b.openTimeout - b.now().Sub(b.openedAt); wait > 0 {
+			return wait, false
+		}
+		b.state = HalfOpen
+		fallthrough
+	case HalfOpen:
+		if b.probing {
+			return probeRetryAfter, false
+		}
+		b.probing = true
+	}
+	return 0, true
+}
+
+func (b *Breaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.state = Closed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *Breaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.threshold {
+		b.state = Open
+		b.openedAt = b.now()
+	}
+	b.probing = false
+}
+
+// Release ends a call whose outcome says nothing about the health of the dependency, e.g.
+// because the client went away.
+func (b *Breaker) Release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.probing = false
+}
+
+// State returns the state of the breaker. An open breaker whose timeout has passed is reported
+// as half-open, because it lets the next call through, even if there hasn't been one yet.
+func (b *Breaker) State() State {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.state == Open && b.now().Sub(b.openedAt) >= b.openTimeout {
+		return HalfOpen
+	}
+	return b.state
+}
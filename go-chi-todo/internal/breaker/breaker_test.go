@@ -0,0 +1,82 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := New(3, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	allow := func(want bool) {
+		t.Helper()
+		if _, ok := b.Allow(); ok != want {
+			t.Fatalf("Want allowed %v in state %v, got %v", want, b.State(), ok)
+		}
+	}
+
+	for range 2 {
+		allow(true)
+		b.Failure()
+	}
+	allow(true)
+	b.Success()
+	if b.State() != Closed {
+		t.Fatalf("Want %v, got %v", Closed, b.State())
+	}
+
+	for range 3 {
+		allow(true)
+		b.Failure()
+	}
+	if b.State() != Open {
+		t.Fatalf("Want %v after consecutive failures, got %v", Open, b.State())
+	}
+	now = now.Add(10 * time.Second)
+	if retryAfter, ok := b.Allow(); ok || retryAfter != 20*time.Second {
+		t.Errorf("Want rejection with retry after %v, got allowed %v, retry after %v", 20*time.Second, ok, retryAfter)
+	}
+
+	// A single probe is let through once the timeout has passed.
+	now = now.Add(20 * time.Second)
+	allow(true)
+	if b.State() != HalfOpen {
+		t.Errorf("Want %v, got %v", HalfOpen, b.State())
+	}
+	allow(false)
+	b.Failure()
+	if b.State() != Open {
+		t.Fatalf("Want %v after failed probe, got %v", Open, b.State())
+	}
+
+	now = now.Add(30 * time.Second)
+	allow(true)
+	b.Release()
+	allow(true)
+	b.Success()
+	if b.State() != Closed {
+		t.Errorf("Want %v after successful probe, got %v", Closed, b.State())
+	}
+	allow(true)
+}
+
+func TestBreakerHalfOpenWithoutCalls(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := New(1, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	b.Allow()
+	b.Failure()
+	if b.State() != Open {
+		t.Fatalf("Want %v, got %v", Open, b.State())
+	}
+	now = now.Add(30 * time.Second)
+	if b.State() != HalfOpen {
+		t.Errorf("Want %v once the timeout has passed, got %v", HalfOpen, b.State())
+	}
+	if _, ok := b.Allow(); !ok {
+		t.Error("Want probe to be allowed")
+	}
+}
@@ -0,0 +1,281 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/health"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// Store guards a model.TodoStore with separate breakers for reads and writes, so the API keeps
+// serving reads while writes fail. It can also be switched to read-only mode explicitly.
+type Store struct {
+	next     model.TodoStore
+	reads    *Breaker
+	writes   *Breaker
+	readOnly atomic.Bool
+}
+
+var _ model.TodoStore = (*Store)(nil)
+
+type Status struct {
+	Reads    string `json:"reads"`
+	Writes   string `json:"writes"`
+	ReadOnly bool   `json:"readOnly"`
+}
+
+func NewStore(next model.TodoStore, reads, writes *Breaker) *Store {
+	return &Store{next: next, reads: reads, writes: writes}
+}
+
+func (s *Store) SetReadOnly(readOnly bool) {
+	if s.readOnly.Swap(readOnly) != readOnly {
+		slog.Warn("changed read-only mode", slog.Bool("readOnly", readOnly))
+	}
+}
+
+func (s *Store) Status() Status {
+	return Status{Reads: s.reads.State().String(), Writes: s.writes.State().String(), ReadOnly: s.readOnly.Load()}
+}
+
+// ReadsAvailable reports whether reads are currently passed on to the wrapped store.
+func (s *Store) ReadsAvailable() bool {
+	return s.reads.State() != Open
+}
+
+// Check is a health check that fails while reads are unavailable and warns while writes are.
+func (s *Store) Check(ctx context.Context) error {
+	switch {
+	case !s.ReadsAvailable():
+		return errors.New("read circuit breaker is open")
+	case s.readOnly.Load():
+		return health.Warnf("store is in read-only mode")
+	case s.writes.State() == Open:
+		return health.Warnf("write circuit breaker is open")
+	}
+	return nil
+}
+
+// isFailure reports whether err indicates a problem with the store rather than with the request.
+func isFailure(err error) bool {
+	var verr *model.ValidationError
+	switch {
+	case err == nil,
+		errors.Is(err, model.ErrEmptyResultSet),
+		errors.Is(err, model.ErrForbidden),
+		errors.Is(err, model.ErrUnauthenticated),
+		errors.Is(err, context.Canceled),
+		errors.As(err, &verr):
+		return false
+	}
+	return true
+}
+
+func call(ctx context.Context, b *Breaker, fn func() error) error {
+	retryAfter, ok := b.Allow()
+	if !ok {
+		return &model.UnavailableError{Reason: "circuit breaker is open", RetryAfter: retryAfter}
+	}
+	err := fn()
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.Canceled):
+		b.Release()
+	case isFailure(err):
+		b.Failure()
+	default:
+		b.Success()
+	}
+	return err
+}
+
+func (s *Store) write(ctx context.Context, fn func() error) error {
+	if s.readOnly.Load() {
+		return &model.UnavailableError{Reason: "the API is in read-only mode"}
+	}
+	return call(ctx, s.writes, fn)
+}
+
+func (s *Store) Find(ctx context.Context, id int) (model.Todo, error) {
+	var item model.Todo
+	err := call(ctx, s.reads, func() (err error) {
+		item, err = s.next.Find(ctx, id)
+		return err
+	})
+	return item, err
+}
+
+func (s *Store) List(ctx context.Context, offset int, limit int) ([]model.Todo, error) {
+	var items []model.Todo
+	err := call(ctx, s.reads, func() (err error) {
+		items, err = s.next.List(ctx, offset, limit)
+		return err
+	})
+	return items, err
+}
+
+func (s *Store) Create(ctx context.Context, item model.Todo) (model.Todo, error) {
+	err := s.write(ctx, func() (err error) {
+		item, err = s.next.Create(ctx, item)
+		return err
+	})
+	return item, err
+}
+
+func (s *Store) Update(ctx context.Context, item model.Todo) (model.Todo, error) {
+	err := s.write(ctx, func() (err error) {
+		item, err = s.next.Update(ctx, item)
+		return err
+	})
+	return item, err
+}
+
+func (s *Store) Delete(ctx context.Context, id int) error {
+	return s.write(ctx, func() error {
+		return s.next.Delete(ctx, id)
+	})
+}
+
+// Ping bypasses the breakers, so readiness checks observe the actual state of the database.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.next.Ping(ctx)
+}
+
+type collaboratorStore struct {
+	s    *Store
+	next model.CollaboratorStore
+}
+
+// WrapCollaborators guards cs with the breakers and read-only mode of s.
+func (s *Store) WrapCollaborators(cs model.CollaboratorStore) model.CollaboratorStore {
+	return &collaboratorStore{s: s, next: cs}
+}
+
+func (c *collaboratorStore) Collaborators(ctx context.Context, todoID int) ([]model.Collaborator, error) {
+	var collaborators []model.Collaborator
+	err := call(ctx, c.s.reads, func() (err error) {
+		collaborators, err = c.next.Collaborators(ctx, todoID)
+		return err
+	})
+	return collaborators, err
+}
+
+func (c *collaboratorStore) Grant(ctx context.Context, todoID int, collaborator model.Collaborator) error {
+	return c.s.write(ctx, func() error {
+		return c.next.Grant(ctx, todoID, collaborator)
+	})
+}
+
+func (c *collaboratorStore) Revoke(ctx context.Context, todoID int, principal string) error {
+	return c.s.write(ctx, func() error {
+		return c.next.Revoke(ctx, todoID, principal)
+	})
+}
+
+type idempotencyStore struct {
+	s    *Store
+	next model.IdempotencyStore
+}
+
+// WrapIdempotency guards is with the write breaker and read-only mode of s. Requests that have
+// reserved a key can still complete or release it in read-only mode.
+func (s *Store) WrapIdempotency(is model.IdempotencyStore) model.IdempotencyStore {
+	return &idempotencyStore{s: s, next: is}
+}
+
+func (i *idempotencyStore) Reserve(ctx context.Context, key string, requestHash []byte, ttl time.Duration) (model.IdempotencyRecord, bool, error) {
+	var rec model.IdempotencyRecord
+	var reserved bool
+	err := i.s.write(ctx, func() (err error) {
+		rec, reserved, err = i.next.Reserve(ctx, key, requestHash, ttl)
+		return err
+	})
+	return rec, reserved, err
+}
+
+func (i *idempotencyStore) Complete(ctx context.Context, key string, res model.IdempotentResponse) error {
+	return call(ctx, i.s.writes, func() error {
+		return i.next.Complete(ctx, key, res)
+	})
+}
+
+func (i *idempotencyStore) Release(ctx context.Context, key string) error {
+	return call(ctx, i.s.writes, func() error {
+		return i.next.Release(ctx, key)
+	})
+}
+
+type listStore struct {
+	s    *Store
+	next model.ListStore
+}
+
+// WrapLists guards ls with the breakers and read-only mode of s.
+func (s *Store) WrapLists(ls model.ListStore) model.ListStore {
+	return &listStore{s: s, next: ls}
+}
+
+func (l *listStore) Lists(ctx context.Context, offset int, limit int) ([]model.List, error) {
+	var lists []model.List
+	err := call(ctx, l.s.reads, func() (err error) {
+		lists, err = l.next.Lists(ctx, offset, limit)
+		return err
+	})
+	return lists, err
+}
+
+func (l *listStore) FindList(ctx context.Context, id int) (model.List, error) {
+	var list model.List
+	err := call(ctx, l.s.reads, func() (err error) {
+		list, err = l.next.FindList(ctx, id)
+		return err
+	})
+	return list, err
+}
+
+func (l *listStore) CreateList(ctx context.Context, list model.List) (model.List, error) {
+	err := l.s.write(ctx, func() (err error) {
+		list, err = l.next.CreateList(ctx, list)
+		return err
+	})
+	return list, err
+}
+
+func (l *listStore) DeleteList(ctx context.Context, id int) error {
+	return l.s.write(ctx, func() error {
+		return l.next.DeleteList(ctx, id)
+	})
+}
+
+func (l *listStore) ListTodos(ctx context.Context, listID int, offset int, limit int) ([]model.Todo, error) {
+	var items []model.Todo
+	err := call(ctx, l.s.reads, func() (err error) {
+		items, err = l.next.ListTodos(ctx, listID, offset, limit)
+		return err
+	})
+	return items, err
+}
+
+func (l *listStore) ListCollaborators(ctx context.Context, listID int) ([]model.Collaborator, error) {
+	var collaborators []model.Collaborator
+	err := call(ctx, l.s.reads, func() (err error) {
+		collaborators, err = l.next.ListCollaborators(ctx, listID)
+		return err
+	})
+	return collaborators, err
+}
+
+func (l *listStore) GrantList(ctx context.Context, listID int, c model.Collaborator) error {
+	return l.s.write(ctx, func() error {
+		return l.next.GrantList(ctx, listID, c)
+	})
+}
+
+func (l *listStore) RevokeList(ctx context.Context, listID int, principal string) error {
+	return l.s.write(ctx, func() error {
+		return l.next.RevokeList(ctx, listID, principal)
+	})
+}
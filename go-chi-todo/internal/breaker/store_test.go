@@ -0,0 +1,126 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+type stubStore struct {
+	err   error
+	calls int
+}
+
+func (s *stubStore) Find(ctx context.Context, id int) (model.Todo, error) {
+	s.calls++
+	return model.Todo{Id: int64(id)}, s.err
+}
+
+func (s *stubStore) List(ctx context.Context, offset int, limit int) ([]model.Todo, error) {
+	s.calls++
+	return nil, s.err
+}
+
+func (s *stubStore) Create(ctx context.Context, item model.Todo) (model.Todo, error) {
+	s.calls++
+	return item, s.err
+}
+
+func (s *stubStore) Update(ctx context.Context, item model.Todo) (model.Todo, error) {
+	s.calls++
+	return item, s.err
+}
+
+func (s *stubStore) Delete(ctx context.Context, id int) error {
+	s.calls++
+	return s.err
+}
+
+func (s *stubStore) Ping(ctx context.Context) error {
+	return s.err
+}
+
+func TestStoreOpens(t *testing.T) {
+	ctx := context.Background()
+	next := &stubStore{err: errors.New("connection refused")}
+	s := NewStore(next, New(2, time.Minute), New(2, time.Minute))
+
+	for range 2 {
+		s.Find(ctx, 1)
+	}
+	_, err := s.Find(ctx, 1)
+	var uerr *model.UnavailableError
+	if !errors.As(err, &uerr) || uerr.RetryAfter <= 0 {
+		t.Fatalf("Want UnavailableError with RetryAfter, got %v", err)
+	}
+	if next.calls != 2 {
+		t.Errorf("Want 2 calls to the wrapped store, got %d", next.calls)
+	}
+	if got := s.Status(); got.Reads != "open" || got.Writes != "closed" {
+		t.Errorf("Want open reads and closed writes, got %+v", got)
+	}
+	if s.ReadsAvailable() {
+		t.Error("Want reads to be unavailable")
+	}
+
+	// Writes have their own breaker.
+	next.err = nil
+	if _, err := s.Create(ctx, model.Todo{}); err != nil {
+		t.Errorf("Want writes to pass, got %v", err)
+	}
+}
+
+func TestStoreIgnoresRequestErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := []error{
+		model.ErrEmptyResultSet,
+		model.ErrForbidden,
+		&model.ValidationError{},
+		context.Canceled,
+	}
+	next := &stubStore{}
+	s := NewStore(next, New(1, time.Minute), New(1, time.Minute))
+	for _, err := range errs {
+		next.err = err
+		s.Update(ctx, model.Todo{})
+	}
+	cancel()
+	next.err = errors.New("driver: bad connection")
+	s.Update(ctx, model.Todo{})
+	if got := s.Status().Writes; got != "closed" {
+		t.Errorf("Want closed breaker, got %s", got)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	ctx := context.Background()
+	next := &stubStore{}
+	s := NewStore(next, New(1, time.Minute), New(1, time.Minute))
+	s.SetReadOnly(true)
+
+	var uerr *model.UnavailableError
+	if _, err := s.Create(ctx, model.Todo{}); !errors.As(err, &uerr) {
+		t.Errorf("Want UnavailableError for Create, got %v", err)
+	}
+	if err := s.Delete(ctx, 1); !errors.As(err, &uerr) {
+		t.Errorf("Want UnavailableError for Delete, got %v", err)
+	}
+	if err := s.WrapCollaborators(nil).Revoke(ctx, 1, "bob"); !errors.As(err, &uerr) {
+		t.Errorf("Want UnavailableError for Revoke, got %v", err)
+	}
+	if _, _, err := s.WrapIdempotency(nil).Reserve(ctx, "key", nil, time.Hour); !errors.As(err, &uerr) {
+		t.Errorf("Want UnavailableError for Reserve, got %v", err)
+	}
+	if _, err := s.Find(ctx, 1); err != nil {
+		t.Errorf("Want reads to pass, got %v", err)
+	}
+	if next.calls != 1 {
+		t.Errorf("Want only the read to reach the wrapped store, got %d calls", next.calls)
+	}
+	if !s.Status().ReadOnly {
+		t.Error("Want read-only status")
+	}
+}
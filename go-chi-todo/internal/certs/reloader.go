@@ -0,0 +1,134 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+// Reloader serves a certificate and an optional client CA bundle from files and picks up
+// changes to these files without a restart, e.g. when a mounted secret volume is updated.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	cert     atomic.Pointer[tls.Certificate]
+	clientCA atomic.Pointer[x509.CertPool]
+	contents [][]byte
+}
+
+func NewReloader(certFile string, keyFile string, clientCAFile string) (*Reloader, error) {
+	r := Reloader{certFile: certFile, keyFile: keyFile, caFile: clientCAFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// reload reads all files and swaps the certificate and CA bundle if any of the files changed.
+// It returns whether a new certificate or CA bundle has been loaded.
+func (r *Reloader) reload() (bool, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	contents := make([][]byte, len(files))
+	for i, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return false, err
+		}
+		contents[i] = b
+	}
+	if r.contents != nil && equal(contents, r.contents) {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return false, fmt.Errorf("loading key pair: %w", err)
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(contents[2]) {
+			return false, errors.New("client CA bundle does not contain any PEM certificates")
+		}
+	}
+
+	r.cert.Store(&cert)
+	r.clientCA.Store(pool)
+	r.contents = contents
+	return true, nil
+}
+
+func equal(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Watch checks the files for changes every interval until ctx is done. If the new files
+// cannot be loaded, the previous certificate remains in use. An interval of zero or less
+// disables reloading.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		slog.Info("TLS certificate reloading disabled")
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			changed, err := r.reload()
+			if err != nil {
+				slog.Error("reloading TLS certificate", log.ErrorKey, err)
+				continue
+			}
+			if changed {
+				slog.Info("reloaded TLS certificate", slog.String("cert", r.certFile), slog.String("clientCA", r.caFile))
+			}
+		}
+	}
+}
+
+// TLSConfig returns a server configuration that always uses the most recently loaded
+// certificate. If the Reloader has a client CA bundle, clients must present a certificate
+// signed by it, unless clientAuth allows otherwise.
+func (r *Reloader) TLSConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	base := tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.cert.Load(), nil
+		},
+	}
+	if r.caFile == "" {
+		return &base
+	}
+	base.ClientAuth = clientAuth
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = r.clientCA.Load()
+		return cfg, nil
+	}
+	return &base
+}
@@ -0,0 +1,173 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Fatal error generating key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn, Organization: []string{"Contoso"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := &tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Fatal error creating certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatalf("Fatal error writing %s: %v", path, err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first := newTestCert(t, "first", nil, false)
+	writeFile(t, certFile, first.certPEM)
+	writeFile(t, keyFile, first.keyPEM)
+
+	r, err := NewReloader(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("Fatal error creating reloader: %v", err)
+	}
+	get := r.TLSConfig(tls.NoClientCert).GetCertificate
+	cert, _ := get(nil)
+	if cert.Leaf.Subject.CommonName != "first" {
+		t.Fatalf("Want certificate %q, got %q", "first", cert.Leaf.Subject.CommonName)
+	}
+
+	if changed, err := r.reload(); changed || err != nil {
+		t.Errorf("Want no change for unchanged files, got changed %v, error %v", changed, err)
+	}
+
+	second := newTestCert(t, "second", nil, false)
+	writeFile(t, certFile, second.certPEM)
+	writeFile(t, keyFile, second.keyPEM)
+	if changed, err := r.reload(); !changed || err != nil {
+		t.Fatalf("Want change, got changed %v, error %v", changed, err)
+	}
+	cert, _ = get(nil)
+	if cert.Leaf.Subject.CommonName != "second" {
+		t.Errorf("Want certificate %q, got %q", "second", cert.Leaf.Subject.CommonName)
+	}
+
+	// A mismatched key pair (e.g. a half-written update) must not replace the current certificate.
+	writeFile(t, keyFile, first.keyPEM)
+	if _, err := r.reload(); err == nil {
+		t.Error("Want error for mismatched key pair")
+	}
+	cert, _ = get(nil)
+	if cert.Leaf.Subject.CommonName != "second" {
+		t.Errorf("Want certificate %q to remain in use, got %q", "second", cert.Leaf.Subject.CommonName)
+	}
+}
+
+func TestWatchDisabled(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var r Reloader
+		r.Watch(context.Background(), 0)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Want Watch to return for a zero interval")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil, true)
+	server := newTestCert(t, "localhost", &ca, false)
+	client := newTestCert(t, "client", &ca, false)
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, server.certPEM)
+	writeFile(t, keyFile, server.keyPEM)
+	writeFile(t, caFile, ca.certPEM)
+
+	r, err := NewReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("Fatal error creating reloader: %v", err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.VerifiedChains[0][0].Subject.String())
+	}))
+	srv.TLS = r.TLSConfig(tls.RequireAndVerifyClientCert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientCert, _ := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+
+	res, err := newClient(clientCert).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Fatal error calling server with client certificate: %v", err)
+	}
+	b, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if want := "CN=client,O=Contoso"; string(b) != want {
+		t.Errorf("Want subject %q, got %q", want, string(b))
+	}
+
+	if _, err := newClient().Get(srv.URL); err == nil {
+		t.Error("Want error calling server without client certificate")
+	}
+}
@@ -0,0 +1,251 @@
+// Package config loads the configuration of the server and the reencrypt job from defaults, an optional YAML or JSON file,
+// environment variables and command line flags, in increasing order of precedence.
+//
+// Each field is named by its json tag in files, by the kebab-case form of its path as a flag
+// (e.g. -tls-cert-file), and by its env tag in the environment. Secrets have no flags.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	ListenAddr string `json:"listenAddr" env:"TODO_LISTEN_ADDR"`
+	Debug      bool   `json:"debug" env:"TODO_DEBUG"`
+	// Set to 1 behind Container Apps ingress, which appends exactly one entry to
+	// X-Forwarded-For. Zero ignores X-Forwarded-For.
+	TrustedProxyHops  int           `json:"trustedProxyHops" env:"TODO_TRUSTED_PROXY_HOPS"`
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout" env:"TODO_READ_HEADER_TIMEOUT"`
+	WriteTimeout      time.Duration `json:"writeTimeout" env:"TODO_WRITE_TIMEOUT"`
+	IdleTimeout       time.Duration `json:"idleTimeout" env:"TODO_IDLE_TIMEOUT"`
+	StartupTimeout    time.Duration `json:"startupTimeout" env:"TODO_STARTUP_TIMEOUT"`
+	// Container Apps sends SIGKILL terminationGracePeriod after SIGTERM, 30 seconds by
+	// default. The drain delay and shutdown timeout must add up to less than that, and closing
+	// the database connections gets what is left.
+	DrainDelay             time.Duration `json:"drainDelay" env:"TODO_DRAIN_DELAY"`
+	ShutdownTimeout        time.Duration `json:"shutdownTimeout" env:"TODO_SHUTDOWN_TIMEOUT"`
+	TerminationGracePeriod time.Duration `json:"terminationGracePeriod" env:"TODO_TERMINATION_GRACE_PERIOD"`
+	IdempotencyTTL         time.Duration `json:"idempotencyTTL" env:"TODO_IDEMPOTENCY_TTL"`
+	ReadOnly               bool          `json:"readOnly" env:"TODO_READ_ONLY"`
+	// Serves /metrics on ListenAddr in addition to the admin listener. Only enable it if
+	// ingress doesn't expose the port publicly.
+	PublicMetrics bool `json:"publicMetrics" env:"TODO_PUBLIC_METRICS"`
+
+	Auth      Auth      `json:"auth"`
+	Database  Database  `json:"database"`
+	Token     Token     `json:"token"`
+	Breaker   Breaker   `json:"breaker"`
+	TLS       TLS       `json:"tls"`
+	CORS      CORS      `json:"cors"`
+	RateLimit RateLimit `json:"rateLimit"`
+	Log       Log       `json:"log"`
+	Admin     Admin     `json:"admin"`
+	Reencrypt Reencrypt `json:"reencrypt"`
+}
+
+type Auth struct {
+	// Only enable if Container Apps built-in authentication is enabled, since the platform
+	// doesn't strip the principal headers from client requests otherwise.
+	TrustEasyAuth bool `json:"trustEasyAuth" env:"TODO_AUTH_TRUST_EASY_AUTH"`
+	// Lets callers without a principal create public todos while trustEasyAuth is set. Without
+	// trustEasyAuth there are no principals, so all todos are created anonymously.
+	AllowAnonymous bool `json:"allowAnonymous" env:"TODO_AUTH_ALLOW_ANONYMOUS"`
+	// Requests with one of these keys in X-API-Key are rate limited per key.
+	APIKeys []string `json:"apiKeys" env:"TODO_AUTH_API_KEYS" secret:"true"`
+}
+
+type Database struct {
+	// An empty connection string uses the libpq PG* environment variables.
+	ConnString    string        `json:"connString" env:"TODO_CONN_STRING" secret:"dsn"`
+	ReplicaConn   string        `json:"replicaConnString" env:"TODO_REPLICA_CONN_STRING" secret:"dsn"`
+	ReplicaMaxLag time.Duration `json:"replicaMaxLag" env:"TODO_REPLICA_MAX_LAG"`
+	// Zero keeps the pgxpool defaults.
+	MinConns int `json:"minConns" env:"TODO_DB_POOL_MIN_CONNS"`
+	MaxConns int `json:"maxConns" env:"TODO_DB_POOL_MAX_CONNS"`
+	// Keep database deadlines below WriteTimeout, so clients get a proper error.
+	Timeout          time.Duration            `json:"timeout" env:"TODO_DB_TIMEOUT"`
+	RouteTimeouts    map[string]time.Duration `json:"routeTimeouts" env:"TODO_DB_ROUTE_TIMEOUTS"`
+	StatementTimeout time.Duration            `json:"statementTimeout" env:"TODO_DB_STATEMENT_TIMEOUT"`
+	// Statements slower than this are logged. Zero disables slow query logging.
+	SlowQuery   time.Duration `json:"slowQuery" env:"TODO_DB_SLOW_QUERY"`
+	KeyringFile string        `json:"keyringFile" env:"TODO_ENCRYPTION_KEYRING_FILE"`
+}
+
+type Token struct {
+	Provider  string `json:"provider" env:"TODO_PG_TOKEN_PROVIDER"`
+	Scope     string `json:"scope" env:"TODO_PG_TOKEN_SCOPE"`
+	ClientID  string `json:"clientId" env:"TODO_PG_CLIENT_ID,AZURE_CLIENT_ID"`
+	TenantID  string `json:"tenantId" env:"TODO_PG_TENANT_ID"`
+	TokenFile string `json:"tokenFile" env:"TODO_PG_TOKEN_FILE"`
+	Token     string `json:"token" env:"TODO_PG_TOKEN" secret:"true"`
+}
+
+type Breaker struct {
+	// Zero disables the circuit breaker.
+	Threshold   int           `json:"threshold" env:"TODO_BREAKER_THRESHOLD"`
+	OpenTimeout time.Duration `json:"openTimeout" env:"TODO_BREAKER_OPEN_TIMEOUT"`
+}
+
+type TLS struct {
+	CertFile     string `json:"certFile" env:"TODO_TLS_CERT_FILE"`
+	KeyFile      string `json:"keyFile" env:"TODO_TLS_KEY_FILE"`
+	ClientCAFile string `json:"clientCAFile" env:"TODO_TLS_CLIENT_CA_FILE"`
+	ClientAuth   string `json:"clientAuth" env:"TODO_TLS_CLIENT_AUTH"`
+	// Zero disables reloading the certificate files.
+	ReloadInterval time.Duration `json:"reloadInterval" env:"TODO_TLS_RELOAD_INTERVAL"`
+}
+
+type CORS struct {
+	AllowedOrigins   []string      `json:"allowedOrigins" env:"TODO_CORS_ALLOWED_ORIGINS"`
+	AllowedMethods   []string      `json:"allowedMethods" env:"TODO_CORS_ALLOWED_METHODS"`
+	AllowedHeaders   []string      `json:"allowedHeaders" env:"TODO_CORS_ALLOWED_HEADERS"`
+	ExposedHeaders   []string      `json:"exposedHeaders" env:"TODO_CORS_EXPOSED_HEADERS"`
+	AllowCredentials bool          `json:"allowCredentials" env:"TODO_CORS_ALLOW_CREDENTIALS"`
+	MaxAge           time.Duration `json:"maxAge" env:"TODO_CORS_MAX_AGE"`
+}
+
+type RateLimit struct {
+	Read  Limit `json:"read" env:"TODO_RATE_LIMIT_READ"`
+	Write Limit `json:"write" env:"TODO_RATE_LIMIT_WRITE"`
+}
+
+// Limit is a token bucket. A zero rate disables limiting, a zero burst defaults to one
+// second's worth of requests.
+type Limit struct {
+	RPS   float64 `json:"rps" env:"_RPS"`
+	Burst int     `json:"burst" env:"_BURST"`
+}
+
+type Log struct {
+	// Failed and slow requests are always logged, the rate only applies to successful ones.
+	AccessSampleRate float64       `json:"accessSampleRate" env:"TODO_ACCESS_LOG_SAMPLE_RATE"`
+	AccessSlow       time.Duration `json:"accessSlow" env:"TODO_ACCESS_LOG_SLOW"`
+	// Log levels changed at runtime are reverted after this duration.
+	LevelRevert time.Duration `json:"levelRevert" env:"TODO_LOG_LEVEL_REVERT"`
+	// Attribute keys masked in logs in addition to log.DefaultRedactedKeys.
+	RedactKeys []string `json:"redactKeys" env:"TODO_LOG_REDACT_KEYS"`
+}
+
+type Admin struct {
+	// Bind to a loopback address or a port that ingress doesn't target. Empty disables the
+	// admin listener.
+	Addr string `json:"addr" env:"TODO_ADMIN_ADDR"`
+	// Required to change log levels on the admin listener.
+	Token string `json:"token" env:"TODO_ADMIN_TOKEN" secret:"true"`
+}
+
+// Reencrypt is only read by the reencrypt job.
+type Reencrypt struct {
+	BatchSize int `json:"batchSize" env:"TODO_REENCRYPT_BATCH_SIZE"`
+	// Decrypts all details instead, which is required before migrating below version 5.
+	Decrypt bool `json:"decrypt" env:"TODO_REENCRYPT_DECRYPT"`
+}
+
+func Default() Config {
+	return Config{
+		ListenAddr:             ":8080",
+		ReadHeaderTimeout:      5 * time.Second,
+		WriteTimeout:           5 * time.Second,
+		IdleTimeout:            120 * time.Second,
+		StartupTimeout:         30 * time.Second,
+		DrainDelay:             5 * time.Second,
+		ShutdownTimeout:        20 * time.Second,
+		TerminationGracePeriod: 30 * time.Second,
+		IdempotencyTTL:         24 * time.Hour,
+		Database: Database{
+			ReplicaMaxLag:    30 * time.Second,
+			Timeout:          3 * time.Second,
+			StatementTimeout: 5 * time.Second,
+			SlowQuery:        500 * time.Millisecond,
+		},
+		Breaker: Breaker{Threshold: 5, OpenTimeout: 30 * time.Second},
+		TLS:     TLS{ReloadInterval: 30 * time.Second},
+		CORS:    CORS{MaxAge: 10 * time.Minute},
+		Log: Log{
+			AccessSampleRate: 1,
+			AccessSlow:       time.Second,
+			LevelRevert:      15 * time.Minute,
+		},
+		Reencrypt: Reencrypt{BatchSize: 100},
+	}
+}
+
+// Validate reports all invalid values at once.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+	positive := func(field string, d time.Duration) {
+		check(d > 0, field, "must be positive, got %s", d)
+	}
+	nonNegative := func(field string, d time.Duration) {
+		check(d >= 0, field, "must not be negative, got %s", d)
+	}
+
+	check(c.ListenAddr != "", "listenAddr", "must not be empty")
+	check(c.TrustedProxyHops >= 0, "trustedProxyHops", "must not be negative, got %d", c.TrustedProxyHops)
+	positive("readHeaderTimeout", c.ReadHeaderTimeout)
+	positive("writeTimeout", c.WriteTimeout)
+	positive("idleTimeout", c.IdleTimeout)
+	positive("startupTimeout", c.StartupTimeout)
+	nonNegative("drainDelay", c.DrainDelay)
+	positive("shutdownTimeout", c.ShutdownTimeout)
+	check(c.DrainDelay+c.ShutdownTimeout < c.TerminationGracePeriod, "shutdownTimeout",
+		"drainDelay %s plus shutdownTimeout %s must be less than terminationGracePeriod %s", c.DrainDelay, c.ShutdownTimeout, c.TerminationGracePeriod)
+	positive("idempotencyTTL", c.IdempotencyTTL)
+
+	db := c.Database
+	positive("database.replicaMaxLag", db.ReplicaMaxLag)
+	check(db.MinConns >= 0, "database.minConns", "must not be negative, got %d", db.MinConns)
+	check(db.MaxConns >= 0 && db.MaxConns <= math.MaxInt32, "database.maxConns", "must be between 0 and %d, got %d", math.MaxInt32, db.MaxConns)
+	check(db.MaxConns == 0 || db.MinConns <= db.MaxConns, "database.minConns", "must not exceed maxConns %d, got %d", db.MaxConns, db.MinConns)
+	nonNegative("database.timeout", db.Timeout)
+	check(db.Timeout < c.WriteTimeout, "database.timeout", "must be less than writeTimeout %s, got %s", c.WriteTimeout, db.Timeout)
+	for route, d := range db.RouteTimeouts {
+		check(d > 0, "database.routeTimeouts", "%q must be positive, got %s", route, d)
+		method, path, ok := strings.Cut(route, " ")
+		check(ok && method == strings.ToUpper(method) && strings.HasPrefix(path, "/"), "database.routeTimeouts", "%q must have the form \"METHOD /path\"", route)
+	}
+	nonNegative("database.statementTimeout", db.StatementTimeout)
+	nonNegative("database.slowQuery", db.SlowQuery)
+
+	switch c.Token.Provider {
+	case "", "default", "managed-identity", "workload-identity", "static", "file":
+	default:
+		check(false, "token.provider", "unknown provider %q", c.Token.Provider)
+	}
+
+	check(c.Breaker.Threshold >= 0, "breaker.threshold", "must not be negative, got %d", c.Breaker.Threshold)
+	positive("breaker.openTimeout", c.Breaker.OpenTimeout)
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls", "certFile and keyFile must be set together")
+	check(c.TLS.ClientCAFile == "" || c.TLS.CertFile != "", "tls.clientCAFile", "requires certFile and keyFile")
+	switch strings.ToLower(c.TLS.ClientAuth) {
+	case "", "require", "optional", "verify-if-given":
+	default:
+		check(false, "tls.clientAuth", "unknown mode %q", c.TLS.ClientAuth)
+	}
+	nonNegative("tls.reloadInterval", c.TLS.ReloadInterval)
+
+	nonNegative("cors.maxAge", c.CORS.MaxAge)
+
+	for name, l := range map[string]Limit{"rateLimit.read": c.RateLimit.Read, "rateLimit.write": c.RateLimit.Write} {
+		check(l.RPS >= 0, name+".rps", "must not be negative, got %g", l.RPS)
+		check(l.Burst >= 0, name+".burst", "must not be negative, got %d", l.Burst)
+	}
+
+	check(c.Log.AccessSampleRate >= 0 && c.Log.AccessSampleRate <= 1, "log.accessSampleRate", "must be between 0 and 1, got %g", c.Log.AccessSampleRate)
+	nonNegative("log.accessSlow", c.Log.AccessSlow)
+	positive("log.levelRevert", c.Log.LevelRevert)
+
+	check(c.Reencrypt.BatchSize > 0, "reencrypt.batchSize", "must be positive, got %d", c.Reencrypt.BatchSize)
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the configuration file if -config isn't given.
+const FileEnv = "TODO_CONFIG_FILE"
+
+// Load returns the effective configuration for the command line args, without the program
+// name. printOnly reports whether -print-config was given. Errors are reported for invalid
+// flags, unreadable files, unknown file keys, unparsable values and by Validate.
+func Load(args []string, lookupEnv func(string) (string, bool)) (cfg Config, printOnly bool, err error) {
+	cfg = Default()
+	fields := fieldsOf(&cfg)
+
+	fs := flag.NewFlagSet("todo", flag.ContinueOnError)
+	file := fs.String("config", "", "YAML or JSON configuration `file` (env "+FileEnv+")")
+	fs.BoolVar(&printOnly, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	flags := make(map[string]string)
+	for _, f := range fields {
+		// Command lines are visible to other processes and in /debug/pprof/cmdline, so secrets
+		// can only be set in the file or environment.
+		if f.secret != "" {
+			continue
+		}
+		fs.Var(&flagValue{name: f.flag, set: flags, isBool: f.v.Kind() == reflect.Bool}, f.flag, f.usage())
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, false, err
+	}
+	if fs.NArg() > 0 {
+		return cfg, false, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	if *file == "" {
+		*file, _ = lookupEnv(FileEnv)
+	}
+	if *file != "" {
+		if err := loadFile(*file, fields); err != nil {
+			return cfg, printOnly, err
+		}
+	}
+
+	var errs []error
+	for _, f := range fields {
+		for _, key := range f.env {
+			if v, ok := lookupEnv(key); ok && v != "" {
+				if err := parse(f.v, v); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				}
+				break
+			}
+		}
+	}
+	for _, f := range fields {
+		if v, ok := flags[f.flag]; ok {
+			if err := parse(f.v, v); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", f.flag, err))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return cfg, printOnly, err
+	}
+
+	for _, l := range []*Limit{&cfg.RateLimit.Read, &cfg.RateLimit.Write} {
+		if l.Burst == 0 && l.RPS > 0 {
+			l.Burst = max(1, int(l.RPS))
+		}
+	}
+	return cfg, printOnly, cfg.Validate()
+}
+
+// field is a settable leaf of Config.
+type field struct {
+	path   string
+	flag   string
+	env    []string
+	secret string
+	v      reflect.Value
+}
+
+func (f field) usage() string {
+	if len(f.env) == 0 {
+		return f.path
+	}
+	return fmt.Sprintf("%s (env %s)", f.path, strings.Join(f.env, ", "))
+}
+
+func fieldsOf(cfg *Config) []field {
+	var fields []field
+	var walk func(v reflect.Value, path, env string)
+	walk = func(v reflect.Value, path, env string) {
+		t := v.Type()
+		for i := range t.NumField() {
+			sf := t.Field(i)
+			name := sf.Tag.Get("json")
+			if path != "" {
+				name = path + "." + name
+			}
+			tag := sf.Tag.Get("env")
+			if sf.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), name, env+tag)
+				continue
+			}
+			f := field{path: name, flag: kebab(name), secret: sf.Tag.Get("secret"), v: v.Field(i)}
+			for key := range strings.SplitSeq(tag, ",") {
+				if key != "" {
+					f.env = append(f.env, env+key)
+				}
+			}
+			fields = append(fields, f)
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "", "")
+	return fields
+}
+
+// kebab turns a field path such as "tls.clientCAFile" into a flag name such as
+// "tls-client-ca-file".
+func kebab(path string) string {
+	var b strings.Builder
+	r := []rune(path)
+	for i, c := range r {
+		switch {
+		case c == '.':
+			b.WriteByte('-')
+			continue
+		case unicode.IsUpper(c) && i > 0 && r[i-1] != '.':
+			if unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1])) {
+				b.WriteByte('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
+
+// flagValue records flags, so they can be applied after the file and environment.
+type flagValue struct {
+	name   string
+	set    map[string]string
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return "" }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+func (f *flagValue) Set(s string) error { f.set[f.name] = s; return nil }
+
+func loadFile(name string, fields []field) error {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	// JSON is valid YAML, so both are decoded the same way.
+	var doc map[string]any
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", name, err)
+	}
+	byPath := make(map[string]field, len(fields))
+	for _, f := range fields {
+		byPath[f.path] = f
+	}
+	var errs []error
+	var walk func(m map[string]any, path string)
+	walk = func(m map[string]any, path string) {
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			f, ok := byPath[p]
+			if sub, isMap := m[k].(map[string]any); isMap && (!ok || f.v.Kind() != reflect.Map) {
+				walk(sub, p)
+				continue
+			}
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown key %q", name, p))
+				continue
+			}
+			if err := set(f.v, m[k]); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", name, p, err))
+			}
+		}
+	}
+	walk(doc, "")
+	return errors.Join(errs...)
+}
+
+// set assigns a decoded file value to v.
+func set(v reflect.Value, x any) error {
+	switch x := x.(type) {
+	case nil:
+		v.SetZero()
+		return nil
+	case []any:
+		if v.Type() != reflect.TypeFor[[]string]() {
+			return fmt.Errorf("want %s, got a list", v.Type())
+		}
+		l := make([]string, 0, len(x))
+		for _, e := range x {
+			l = append(l, fmt.Sprint(e))
+		}
+		v.Set(reflect.ValueOf(l))
+		return nil
+	case map[string]any:
+		if v.Type() != reflect.TypeFor[map[string]time.Duration]() {
+			return fmt.Errorf("want %s, got a map", v.Type())
+		}
+		m := make(map[string]time.Duration, len(x))
+		for k, e := range x {
+			d, err := time.ParseDuration(fmt.Sprint(e))
+			if err != nil {
+				return fmt.Errorf("%q: %w", k, err)
+			}
+			m[k] = d
+		}
+		v.Set(reflect.ValueOf(m))
+		return nil
+	default:
+		return parse(v, fmt.Sprint(x))
+	}
+}
+
+// parse assigns a string from the environment or a flag to v.
+func parse(v reflect.Value, s string) error {
+	switch v.Interface().(type) {
+	case string:
+		v.SetString(s)
+	case bool:
+		switch strings.ToLower(s) {
+		case "yes", "true", "on", "1":
+			v.SetBool(true)
+		case "no", "false", "off", "0":
+			v.SetBool(false)
+		default:
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+	case int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", s)
+		}
+		v.SetFloat(f)
+	case time.Duration:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		v.SetInt(int64(d))
+	case []string:
+		v.Set(reflect.ValueOf(list(s)))
+	case map[string]time.Duration:
+		// A list such as "GET /todo=5s,PUT /todo/{id}=2s".
+		m := make(map[string]time.Duration)
+		for _, e := range list(s) {
+			k, d, ok := strings.Cut(e, "=")
+			if !ok {
+				return fmt.Errorf("invalid entry %q, want key=duration", e)
+			}
+			dur, err := time.ParseDuration(strings.TrimSpace(d))
+			if err != nil {
+				return fmt.Errorf("invalid duration in %q", e)
+			}
+			m[strings.TrimSpace(k)] = dur
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func list(s string) []string {
+	var l []string
+	for v := range strings.SplitSeq(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
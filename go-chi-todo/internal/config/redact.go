@@ -0,0 +1,60 @@
+package config
+
+import (
+	"strings"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+// Redacted returns the configuration keyed like a config file, with secrets masked and
+// durations formatted as strings.
+func (c Config) Redacted() map[string]any {
+	root := make(map[string]any)
+	for _, f := range fieldsOf(&c) {
+		m := root
+		keys := strings.Split(f.path, ".")
+		for _, k := range keys[:len(keys)-1] {
+			sub, ok := m[k].(map[string]any)
+			if !ok {
+				sub = make(map[string]any)
+				m[k] = sub
+			}
+			m = sub
+		}
+		m[keys[len(keys)-1]] = redactedValue(f)
+	}
+	return root
+}
+
+func redactedValue(f field) any {
+	switch v := f.v.Interface().(type) {
+	case string:
+		switch {
+		case f.secret == "dsn":
+			return log.Redact(v)
+		case f.secret != "" && v != "":
+			return log.Redacted
+		}
+		return v
+	case time.Duration:
+		return v.String()
+	case []string:
+		l := make([]string, 0, len(v))
+		for _, e := range v {
+			if f.secret != "" {
+				e = log.Redacted
+			}
+			l = append(l, e)
+		}
+		return l
+	case map[string]time.Duration:
+		m := make(map[string]string, len(v))
+		for k, d := range v {
+			m[k] = d.String()
+		}
+		return m
+	default:
+		return v
+	}
+}
@@ -0,0 +1,230 @@
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	DefaultMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+	DefaultHeaders = []string{"Content-Type", "Authorization", "Idempotency-Key", "X-Read-Your-Writes", "X-Request-ID"}
+	DefaultExposed = []string{"Location", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Idempotent-Replayed", "X-Request-ID"}
+)
+
+type Config struct {
+	// AllowedOrigins lists exact origins such as "https://app.example.com", wildcard
+	// subdomains such as "https://*.example.com", or "*" to allow any origin.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+type originPattern struct {
+	scheme string
+	host   string // Without the leading "*" for wildcard patterns.
+	port   string
+	suffix bool
+}
+
+type Policy struct {
+	anyOrigin   bool
+	origins     []originPattern
+	methods     []string
+	headers     []string
+	anyHeader   bool
+	allowMethod string
+	allowHeader string
+	expose      string
+	credentials bool
+	maxAge      string
+}
+
+func New(cfg Config) (*Policy, error) {
+	p := Policy{credentials: cfg.AllowCredentials}
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		op, err := parseOrigin(o)
+		if err != nil {
+			return nil, err
+		}
+		p.origins = append(p.origins, op)
+	}
+	if p.anyOrigin && p.credentials {
+		return nil, errors.New("cors: credentials cannot be allowed for any origin")
+	}
+
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	for _, m := range methods {
+		p.methods = append(p.methods, strings.ToUpper(strings.TrimSpace(m)))
+	}
+	p.allowMethod = strings.Join(p.methods, ", ")
+
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = DefaultHeaders
+	}
+	for _, h := range headers {
+		h = strings.TrimSpace(h)
+		if h == "*" {
+			p.anyHeader = true
+			continue
+		}
+		p.headers = append(p.headers, http.CanonicalHeaderKey(h))
+	}
+	p.allowHeader = strings.Join(p.headers, ", ")
+
+	exposed := cfg.ExposedHeaders
+	if exposed == nil {
+		exposed = DefaultExposed
+	}
+	p.expose = strings.Join(exposed, ", ")
+
+	if cfg.MaxAge < 0 {
+		return nil, fmt.Errorf("cors: invalid max age %v", cfg.MaxAge)
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	return &p, nil
+}
+
+func parseOrigin(o string) (originPattern, error) {
+	u, err := url.Parse(o)
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return originPattern{}, fmt.Errorf("cors: invalid origin %q", o)
+	}
+	// url.Parse rejects "*" in hosts with a port, so split it off manually.
+	host, port, _ := strings.Cut(u.Host, ":")
+	scheme := strings.ToLower(u.Scheme)
+	op := originPattern{scheme: scheme, host: strings.ToLower(host), port: normalizePort(scheme, port)}
+	if rest, ok := strings.CutPrefix(op.host, "*."); ok {
+		if rest == "" || strings.Contains(rest, "*") {
+			return originPattern{}, fmt.Errorf("cors: invalid origin %q", o)
+		}
+		op.host = "." + rest
+		op.suffix = true
+	} else if strings.Contains(op.host, "*") {
+		return originPattern{}, fmt.Errorf("cors: invalid origin %q", o)
+	}
+	return op, nil
+}
+
+func (p *Policy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := normalizePort(scheme, u.Port())
+	for _, op := range p.origins {
+		if op.scheme != scheme || op.port != port {
+			continue
+		}
+		if op.suffix && strings.HasSuffix(host, op.host) || !op.suffix && host == op.host {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizePort drops the default port of scheme, since browsers omit it from Origin.
+func normalizePort(scheme, port string) string {
+	if scheme == "https" && port == "443" || scheme == "http" && port == "80" {
+		return ""
+	}
+	return port
+}
+
+func (p *Policy) allowHeaders(requested string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for h := range strings.SplitSeq(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h != "" && !slices.Contains(p.headers, http.CanonicalHeaderKey(h)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Handler answers preflight requests without calling next and adds CORS headers to
+// all other requests from allowed origins.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// Responses differ by origin unless any origin is allowed, so caches must key on it.
+		if !p.anyOrigin {
+			h.Add("Vary", "Origin")
+		}
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			p.preflight(w, r, origin)
+			return
+		}
+		if origin != "" && p.allowOrigin(origin) {
+			p.setOrigin(h, origin)
+			if p.expose != "" {
+				h.Set("Access-Control-Expose-Headers", p.expose)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Policy) preflight(w http.ResponseWriter, r *http.Request, origin string) {
+	h := w.Header()
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	requested := r.Header.Get("Access-Control-Request-Headers")
+	if origin == "" || !p.allowOrigin(origin) || !slices.Contains(p.methods, method) || !p.allowHeaders(requested) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	p.setOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", p.allowMethod)
+	if p.anyHeader {
+		if requested != "" {
+			h.Set("Access-Control-Allow-Headers", requested)
+		}
+	} else if p.allowHeader != "" {
+		h.Set("Access-Control-Allow-Headers", p.allowHeader)
+	}
+	if p.maxAge != "" {
+		h.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (p *Policy) setOrigin(h http.Header, origin string) {
+	if p.anyOrigin {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if p.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
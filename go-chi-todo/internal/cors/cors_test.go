@@ -0,0 +1,223 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{name: "exact", cfg: Config{AllowedOrigins: []string{"https://app.example.com"}}},
+		{name: "wildcard_subdomain", cfg: Config{AllowedOrigins: []string{"https://*.example.com"}}},
+		{name: "with_port", cfg: Config{AllowedOrigins: []string{"http://localhost:5173"}}},
+		{name: "any", cfg: Config{AllowedOrigins: []string{"*"}}},
+		{name: "any_with_credentials", cfg: Config{AllowedOrigins: []string{"*"}, AllowCredentials: true}, wantErr: true},
+		{name: "missing_scheme", cfg: Config{AllowedOrigins: []string{"app.example.com"}}, wantErr: true},
+		{name: "with_path", cfg: Config{AllowedOrigins: []string{"https://app.example.com/spa"}}, wantErr: true},
+		{name: "wildcard_in_middle", cfg: Config{AllowedOrigins: []string{"https://app.*.com"}}, wantErr: true},
+		{name: "negative_max_age", cfg: Config{AllowedOrigins: []string{"*"}, MaxAge: -time.Second}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := New(tc.cfg); (err != nil) != tc.wantErr {
+				t.Errorf("Want error %v, got error %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestAllowOrigin(t *testing.T) {
+	p, err := New(Config{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org", "http://localhost:5173", "https://api.example.net:443"}})
+	if err != nil {
+		t.Fatalf("Fatal error creating policy: %v", err)
+	}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{origin: "https://app.example.com", want: true},
+		{origin: "https://APP.example.com", want: true},
+		{origin: "http://app.example.com", want: false},
+		{origin: "https://app.example.com:8443", want: false},
+		{origin: "https://other.example.com", want: false},
+		{origin: "https://a.example.org", want: true},
+		{origin: "https://a.b.example.org", want: true},
+		{origin: "https://example.org", want: false},
+		{origin: "https://evilexample.org", want: false},
+		{origin: "http://localhost:5173", want: true},
+		{origin: "http://localhost", want: false},
+		{origin: "https://app.example.com:443", want: true},
+		{origin: "https://api.example.net", want: true},
+		{origin: "https://api.example.net:443", want: true},
+		{origin: "http://api.example.net:443", want: false},
+		{origin: "null", want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.origin, func(t *testing.T) {
+			if got := p.allowOrigin(tc.origin); got != tc.want {
+				t.Errorf("Want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestHandler(t *testing.T) {
+	p, err := New(Config{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Fatal error creating policy: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		origin     string
+		reqMethod  string
+		reqHeaders string
+		want       int
+		wantOrigin string
+		wantNext   bool
+	}{
+		{
+			name:       "preflight",
+			method:     http.MethodOptions,
+			origin:     "https://app.example.com",
+			reqMethod:  http.MethodPost,
+			reqHeaders: "content-type, idempotency-key",
+			want:       http.StatusNoContent,
+			wantOrigin: "https://app.example.com",
+		},
+		{
+			name:      "preflight_origin_denied",
+			method:    http.MethodOptions,
+			origin:    "https://app.example.net",
+			reqMethod: http.MethodPost,
+			want:      http.StatusForbidden,
+		},
+		{
+			name:      "preflight_method_denied",
+			method:    http.MethodOptions,
+			origin:    "https://app.example.com",
+			reqMethod: http.MethodPatch,
+			want:      http.StatusForbidden,
+		},
+		{
+			name:       "preflight_header_denied",
+			method:     http.MethodOptions,
+			origin:     "https://app.example.com",
+			reqMethod:  http.MethodPost,
+			reqHeaders: "x-custom",
+			want:       http.StatusForbidden,
+		},
+		{
+			name:       "simple_request",
+			method:     http.MethodGet,
+			origin:     "https://app.example.com",
+			want:       http.StatusOK,
+			wantOrigin: "https://app.example.com",
+			wantNext:   true,
+		},
+		{
+			name:     "simple_request_origin_denied",
+			method:   http.MethodGet,
+			origin:   "https://app.example.net",
+			want:     http.StatusOK,
+			wantNext: true,
+		},
+		{
+			name:     "same_origin",
+			method:   http.MethodGet,
+			want:     http.StatusOK,
+			wantNext: true,
+		},
+		{
+			name:       "options_without_preflight",
+			method:     http.MethodOptions,
+			origin:     "https://app.example.com",
+			want:       http.StatusOK,
+			wantOrigin: "https://app.example.com",
+			wantNext:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var called bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+			})
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.method, "/todo", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.reqMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tc.reqMethod)
+			}
+			if tc.reqHeaders != "" {
+				r.Header.Set("Access-Control-Request-Headers", tc.reqHeaders)
+			}
+			p.Handler(next).ServeHTTP(w, r)
+			res := w.Result()
+
+			if res.StatusCode != tc.want {
+				t.Errorf("Want status code %d, got %d", tc.want, res.StatusCode)
+			}
+			if called != tc.wantNext {
+				t.Errorf("Want next handler called %v, got %v", tc.wantNext, called)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Origin"); got != tc.wantOrigin {
+				t.Errorf("Want Access-Control-Allow-Origin %q, got %q", tc.wantOrigin, got)
+			}
+			vary := res.Header.Values("Vary")
+			if !slices.Contains(vary, "Origin") {
+				t.Errorf("Want Vary: Origin, got %v", vary)
+			}
+			if tc.reqMethod != "" && !slices.Contains(vary, "Access-Control-Request-Method") {
+				t.Errorf("Want Vary: Access-Control-Request-Method, got %v", vary)
+			}
+			if tc.wantOrigin == "" {
+				return
+			}
+			if got := res.Header.Get("Access-Control-Allow-Credentials"); got != "true" {
+				t.Errorf("Want Access-Control-Allow-Credentials true, got %q", got)
+			}
+			if tc.reqMethod != "" {
+				if got := res.Header.Get("Access-Control-Max-Age"); got != "3600" {
+					t.Errorf("Want Access-Control-Max-Age 3600, got %q", got)
+				}
+				if got := res.Header.Get("Access-Control-Allow-Methods"); got == "" {
+					t.Error("Want Access-Control-Allow-Methods to be set")
+				}
+			} else if got := res.Header.Get("Access-Control-Expose-Headers"); got == "" {
+				t.Error("Want Access-Control-Expose-Headers to be set")
+			}
+		})
+	}
+}
+
+func TestHandlerAnyOrigin(t *testing.T) {
+	p, err := New(Config{AllowedOrigins: []string{"*"}})
+	if err != nil {
+		t.Fatalf("Fatal error creating policy: %v", err)
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	r.Header.Set("Origin", "https://anywhere.example")
+	p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Want Access-Control-Allow-Origin *, got %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "" {
+		t.Errorf("Want no Vary header, got %q", got)
+	}
+}
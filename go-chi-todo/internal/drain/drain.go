@@ -0,0 +1,61 @@
+// Package drain tracks in-flight HTTP requests and fails readiness while the server is
+// draining, so the ingress stops routing new requests to a replica before it shuts down.
+package drain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+var ErrDraining = errors.New("server is draining")
+
+// Drainer counts in-flight requests. The zero value is ready to use.
+type Drainer struct {
+	draining  atomic.Bool
+	inFlight  atomic.Int64
+	completed atomic.Int64
+}
+
+type Stats struct {
+	InFlight  int64
+	Completed int64
+}
+
+func (d *Drainer) Stats() Stats {
+	return Stats{InFlight: d.inFlight.Load(), Completed: d.completed.Load()}
+}
+
+// Start puts the drainer into draining mode. It returns false if it was already draining.
+func (d *Drainer) Start() bool {
+	return !d.draining.Swap(true)
+}
+
+func (d *Drainer) Draining() bool {
+	return d.draining.Load()
+}
+
+// Check is a health check that fails once draining has started.
+func (d *Drainer) Check(ctx context.Context) error {
+	if d.Draining() {
+		return ErrDraining
+	}
+	return nil
+}
+
+// Handler tracks requests served by next. While draining, responses ask clients to close the
+// connection, so kept-alive connections move to other replicas.
+func (d *Drainer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.inFlight.Add(1)
+		defer func() {
+			d.inFlight.Add(-1)
+			d.completed.Add(1)
+		}()
+		if d.Draining() {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
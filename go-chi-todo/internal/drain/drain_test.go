@@ -0,0 +1,53 @@
+package drain
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDrainer(t *testing.T) {
+	var d Drainer
+	started, release, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	blocking := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	noop := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if err := d.Check(context.Background()); err != nil {
+		t.Fatalf("Want passing check before draining, got %v", err)
+	}
+	go func() {
+		defer close(done)
+		blocking.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/todo", nil))
+	}()
+	<-started
+	if got := d.Stats(); got.InFlight != 1 {
+		t.Errorf("Want 1 request in flight, got %d", got.InFlight)
+	}
+
+	if !d.Start() {
+		t.Error("Want Start to return true the first time")
+	}
+	if d.Start() {
+		t.Error("Want Start to return false when already draining")
+	}
+	if err := d.Check(context.Background()); !errors.Is(err, ErrDraining) {
+		t.Errorf("Want %v, got %v", ErrDraining, err)
+	}
+
+	w := httptest.NewRecorder()
+	noop.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todo", nil))
+	if got := w.Header().Get("Connection"); got != "close" {
+		t.Errorf("Want Connection %q while draining, got %q", "close", got)
+	}
+
+	close(release)
+	<-done
+	if got := d.Stats(); got.InFlight != 0 || got.Completed != 2 {
+		t.Errorf("Want 0 requests in flight and 2 completed, got %+v", got)
+	}
+}
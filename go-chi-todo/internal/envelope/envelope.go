@@ -0,0 +1,126 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// KeyWrapper encrypts data encryption keys (DEKs) with a versioned key encryption key (KEK).
+// It mirrors the wrapKey and unwrapKey operations of Azure Key Vault, so a Key Vault backed
+// implementation can replace the local keyring without changing stored data.
+type KeyWrapper interface {
+	// WrapKey encrypts dek with the current KEK and returns the version of the KEK used.
+	WrapKey(ctx context.Context, dek []byte) (wrapped []byte, version string, err error)
+	UnwrapKey(ctx context.Context, version string, wrapped []byte) ([]byte, error)
+	CurrentVersion(ctx context.Context) (string, error)
+}
+
+// Sealed is a value encrypted with its own DEK. Only WrappedKey depends on the KEK, so
+// rotating the KEK only requires rewrapping the DEK, not re-encrypting the value.
+type Sealed struct {
+	Ciphertext []byte
+	WrappedKey []byte
+	KeyVersion string
+}
+
+var ErrDecrypt = errors.New("envelope: decryption failed")
+
+type Encryptor struct {
+	kw KeyWrapper
+}
+
+func New(kw KeyWrapper) *Encryptor {
+	return &Encryptor{kw: kw}
+}
+
+// Seal encrypts plaintext with a new AES-256-GCM DEK. additionalData is authenticated but
+// not encrypted and must be passed to Open unchanged, e.g. to bind a value to its column.
+func (e *Encryptor) Seal(ctx context.Context, plaintext []byte, additionalData []byte) (Sealed, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return Sealed{}, err
+	}
+	ciphertext, err := seal(dek, plaintext, additionalData)
+	if err != nil {
+		return Sealed{}, err
+	}
+	wrapped, version, err := e.kw.WrapKey(ctx, dek)
+	if err != nil {
+		return Sealed{}, fmt.Errorf("wrapping data encryption key: %w", err)
+	}
+	return Sealed{Ciphertext: ciphertext, WrappedKey: wrapped, KeyVersion: version}, nil
+}
+
+func (e *Encryptor) Open(ctx context.Context, s Sealed, additionalData []byte) ([]byte, error) {
+	dek, err := e.kw.UnwrapKey(ctx, s.KeyVersion, s.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data encryption key: %w", err)
+	}
+	return open(dek, s.Ciphertext, additionalData)
+}
+
+// Rewrap wraps the DEK of s with the current KEK. It reports false if s already uses the
+// current KEK.
+func (e *Encryptor) Rewrap(ctx context.Context, s Sealed) (Sealed, bool, error) {
+	current, err := e.kw.CurrentVersion(ctx)
+	if err != nil {
+		return s, false, err
+	}
+	if s.KeyVersion == current {
+		return s, false, nil
+	}
+	dek, err := e.kw.UnwrapKey(ctx, s.KeyVersion, s.WrappedKey)
+	if err != nil {
+		return s, false, fmt.Errorf("unwrapping data encryption key: %w", err)
+	}
+	wrapped, version, err := e.kw.WrapKey(ctx, dek)
+	if err != nil {
+		return s, false, fmt.Errorf("wrapping data encryption key: %w", err)
+	}
+	return Sealed{Ciphertext: s.Ciphertext, WrappedKey: wrapped, KeyVersion: version}, true, nil
+}
+
+func (e *Encryptor) CurrentVersion(ctx context.Context) (string, error) {
+	return e.kw.CurrentVersion(ctx)
+}
+
+// seal encrypts plaintext with AES-GCM and prepends the random nonce.
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,114 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestSealOpen(t *testing.T) {
+	ctx := context.Background()
+	kr, err := NewKeyring("v1", map[string][]byte{"v1": testKey(1)})
+	if err != nil {
+		t.Fatalf("Fatal error creating keyring: %v", err)
+	}
+	e := New(kr)
+	plaintext := []byte("check if this is working")
+
+	s, err := e.Seal(ctx, plaintext, []byte("todo.details"))
+	if err != nil {
+		t.Fatalf("Fatal error sealing: %v", err)
+	}
+	if s.KeyVersion != "v1" {
+		t.Errorf("Want key version %q, got %q", "v1", s.KeyVersion)
+	}
+	if bytes.Contains(s.Ciphertext, plaintext) {
+		t.Error("Want ciphertext not to contain plaintext")
+	}
+	got, err := e.Open(ctx, s, []byte("todo.details"))
+	if err != nil {
+		t.Fatalf("Fatal error opening: %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Want %q, got %q", plaintext, got)
+	}
+
+	if _, err := e.Open(ctx, s, []byte("todo.description")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Want ErrDecrypt for different additional data, got %v", err)
+	}
+	tampered := s
+	tampered.Ciphertext = append([]byte{}, s.Ciphertext...)
+	tampered.Ciphertext[len(tampered.Ciphertext)-1] ^= 1
+	if _, err := e.Open(ctx, tampered, []byte("todo.details")); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("Want ErrDecrypt for tampered ciphertext, got %v", err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	ctx := context.Background()
+	old, _ := NewKeyring("v1", map[string][]byte{"v1": testKey(1)})
+	s, err := New(old).Seal(ctx, []byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("Fatal error sealing: %v", err)
+	}
+
+	rotated, _ := NewKeyring("v2", map[string][]byte{"v1": testKey(1), "v2": testKey(2)})
+	e := New(rotated)
+	rewrapped, changed, err := e.Rewrap(ctx, s)
+	if err != nil || !changed {
+		t.Fatalf("Want rewrapped key, got changed %v, error %v", changed, err)
+	}
+	if rewrapped.KeyVersion != "v2" {
+		t.Errorf("Want key version %q, got %q", "v2", rewrapped.KeyVersion)
+	}
+	if !bytes.Equal(rewrapped.Ciphertext, s.Ciphertext) {
+		t.Error("Want ciphertext to be unchanged by rewrapping")
+	}
+	if _, changed, _ := e.Rewrap(ctx, rewrapped); changed {
+		t.Error("Want no change for current key version")
+	}
+
+	// Once v1 is retired, only rewrapped values can be decrypted.
+	retired, _ := NewKeyring("v2", map[string][]byte{"v2": testKey(2)})
+	if got, err := New(retired).Open(ctx, rewrapped, nil); err != nil || string(got) != "secret" {
+		t.Errorf("Want %q, got %q, error %v", "secret", got, err)
+	}
+	if _, err := New(retired).Open(ctx, s, nil); err == nil {
+		t.Error("Want error opening value wrapped with retired key")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir := t.TempDir()
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "valid", content: `{"current": "v1", "keys": {"v1": "` + k1 + `"}}`},
+		{name: "missing_current", content: `{"current": "v2", "keys": {"v1": "` + k1 + `"}}`, wantErr: true},
+		{name: "short_key", content: `{"current": "v1", "keys": {"v1": "AAAA"}}`, wantErr: true},
+		{name: "invalid_base64", content: `{"current": "v1", "keys": {"v1": "!"}}`, wantErr: true},
+		{name: "invalid_json", content: `{`, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name+".json")
+			if err := os.WriteFile(path, []byte(tc.content), 0o600); err != nil {
+				t.Fatalf("Fatal error writing keyring: %v", err)
+			}
+			if _, err := LoadKeyring(path); (err != nil) != tc.wantErr {
+				t.Errorf("Want error %v, got error %v", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package envelope
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Keyring is a KeyWrapper backed by locally stored AES-256 keys, for development, tests
+// and environments where keys are mounted as secrets.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+var _ KeyWrapper = (*Keyring)(nil)
+
+type keyringFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"` // Version to base64 encoded 256-bit key.
+}
+
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("keyring: current key version %q not found", current)
+	}
+	for v, k := range keys {
+		if len(k) != 32 {
+			return nil, fmt.Errorf("keyring: key version %q must be 32 bytes, got %d", v, len(k))
+		}
+	}
+	return &Keyring{current: current, keys: keys}, nil
+}
+
+// LoadKeyring reads a keyring from a JSON file such as
+//
+//	{"current": "v2", "keys": {"v1": "<base64>", "v2": "<base64>"}}
+func LoadKeyring(path string) (*Keyring, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f keyringFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("keyring: %w", err)
+	}
+	keys := make(map[string][]byte, len(f.Keys))
+	for v, enc := range f.Keys {
+		k, err := base64.StdEncoding.DecodeString(enc)
+		if err != nil {
+			return nil, fmt.Errorf("keyring: decoding key version %q: %w", v, err)
+		}
+		keys[v] = k
+	}
+	return NewKeyring(f.Current, keys)
+}
+
+func (k *Keyring) WrapKey(_ context.Context, dek []byte) ([]byte, string, error) {
+	wrapped, err := seal(k.keys[k.current], dek, []byte(k.current))
+	if err != nil {
+		return nil, "", err
+	}
+	return wrapped, k.current, nil
+}
+
+func (k *Keyring) UnwrapKey(_ context.Context, version string, wrapped []byte) ([]byte, error) {
+	kek, ok := k.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("keyring: unknown key version %q", version)
+	}
+	return open(kek, wrapped, []byte(version))
+}
+
+func (k *Keyring) CurrentVersion(context.Context) (string, error) {
+	return k.current, nil
+}
@@ -0,0 +1,187 @@
+// Package health runs named component checks and aggregates them into a report for the
+// readiness and startup probes.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTimeout  = 2 * time.Second
+	DefaultCacheTTL = 5 * time.Second
+)
+
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Check reports a component's health. A nil error passes, an error wrapped with Warning
+// reports a degraded component, and any other error fails.
+type Check func(ctx context.Context) error
+
+type warning struct {
+	err error
+}
+
+func (w *warning) Error() string { return w.err.Error() }
+func (w *warning) Unwrap() error { return w.err }
+
+// Warning marks err as a warning. Warnings are reported, but don't fail the probe.
+func Warning(err error) error {
+	return &warning{err: err}
+}
+
+// Warnf is a shorthand for Warning(fmt.Errorf(format, args...)).
+func Warnf(format string, args ...any) error {
+	return Warning(fmt.Errorf(format, args...))
+}
+
+type Result struct {
+	Status    Status        `json:"status"`
+	Output    string        `json:"output,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	CheckedAt time.Time     `json:"checkedAt"`
+}
+
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type Option func(*check)
+
+// WithTimeout limits how long the check may run before it fails.
+func WithTimeout(d time.Duration) Option {
+	return func(c *check) {
+		c.timeout = d
+	}
+}
+
+// WithCacheTTL sets how long a result is reused before the check runs again.
+func WithCacheTTL(d time.Duration) Option {
+	return func(c *check) {
+		c.ttl = d
+	}
+}
+
+type check struct {
+	name    string
+	fn      Check
+	timeout time.Duration
+	ttl     time.Duration
+
+	// mu is held while the check runs, so concurrent probes share a single run.
+	mu     sync.Mutex
+	result Result
+}
+
+// Registry holds the checks of all components.
+type Registry struct {
+	mu     sync.RWMutex
+	checks map[string]*check
+	now    func() time.Time
+}
+
+func New() *Registry {
+	return &Registry{checks: make(map[string]*check), now: time.Now}
+}
+
+// Register adds a check. Registering a name again replaces the previous check.
+func (r *Registry) Register(name string, fn Check, opts ...Option) {
+	c := &check{name: name, fn: fn, timeout: DefaultTimeout, ttl: DefaultCacheTTL}
+	for _, opt := range opts {
+		opt(c)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = c
+}
+
+// Names returns the names of all registered checks in sorted order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run runs all checks concurrently, reusing cached results, and returns the report. The
+// report's status is the worst status of any check.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := make([]*check, 0, len(r.checks))
+	for _, c := range r.checks {
+		checks = append(checks, c)
+	}
+	r.mu.RUnlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Go(func() {
+			results[i] = r.run(ctx, c)
+		})
+	}
+	wg.Wait()
+
+	report := Report{Status: Pass, Checks: make(map[string]Result, len(checks))}
+	for i, c := range checks {
+		res := results[i]
+		report.Checks[c.name] = res
+		if res.Status == Fail || res.Status == Warn && report.Status == Pass {
+			report.Status = res.Status
+		}
+	}
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, c *check) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	start := r.now()
+	if !c.result.CheckedAt.IsZero() && start.Sub(c.result.CheckedAt) < c.ttl {
+		return c.result
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	// Run the check in its own goroutine, so a check that ignores its context can't block
+	// the probe beyond the timeout.
+	errC := make(chan error, 1)
+	go func() {
+		errC <- c.fn(checkCtx)
+	}()
+	var err error
+	select {
+	case err = <-errC:
+	case <-checkCtx.Done():
+		err = fmt.Errorf("check timed out after %s", c.timeout)
+	}
+
+	res := Result{Status: Pass, CheckedAt: start, Duration: r.now().Sub(start)}
+	var w *warning
+	switch {
+	case errors.As(err, &w):
+		res.Status, res.Output = Warn, err.Error()
+	case err != nil:
+		res.Status, res.Output = Fail, err.Error()
+	}
+	// Don't cache results of probes that were canceled by the caller.
+	if ctx.Err() == nil {
+		c.result = res
+	}
+	return res
+}
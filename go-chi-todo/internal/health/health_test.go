@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	pass := func(ctx context.Context) error { return nil }
+	warn := func(ctx context.Context) error { return Warnf("token expires in %s", time.Minute) }
+	fail := func(ctx context.Context) error { return errors.New("connection refused") }
+	hang := func(ctx context.Context) error {
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}
+
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		want   Status
+	}{
+		{"no checks", nil, Pass},
+		{"pass", map[string]Check{"database": pass, "token": pass}, Pass},
+		{"warn", map[string]Check{"database": pass, "token": warn}, Warn},
+		{"fail", map[string]Check{"database": fail, "token": warn}, Fail},
+		{"timeout", map[string]Check{"database": hang}, Fail},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New()
+			for name, fn := range tc.checks {
+				r.Register(name, fn, WithTimeout(10*time.Millisecond))
+			}
+			report := r.Run(context.Background())
+			if report.Status != tc.want {
+				t.Errorf("Want status %q, got %q", tc.want, report.Status)
+			}
+			if len(report.Checks) != len(tc.checks) {
+				t.Errorf("Want %d results, got %d", len(tc.checks), len(report.Checks))
+			}
+			for name, res := range report.Checks {
+				if res.Status != Pass && res.Output == "" {
+					t.Errorf("Want output for %q with status %q", name, res.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestRunCached(t *testing.T) {
+	now := time.Now()
+	r := New()
+	r.now = func() time.Time { return now }
+	var calls atomic.Int32
+	r.Register("database", func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	}, WithCacheTTL(time.Minute))
+
+	r.Run(context.Background())
+	r.Run(context.Background())
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Want 1 call within TTL, got %d", got)
+	}
+
+	now = now.Add(time.Minute)
+	r.Run(context.Background())
+	if got := calls.Load(); got != 2 {
+		t.Errorf("Want 2 calls after TTL, got %d", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	now = now.Add(time.Minute)
+	r.Run(ctx)
+	r.Run(context.Background())
+	// The canceled run's check may still be running, so only the second run is certain.
+	if got := calls.Load(); got < 3 {
+		t.Errorf("Want canceled run not to be cached, got %d calls", got)
+	}
+}
@@ -0,0 +1,67 @@
+package log
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+)
+
+// ContextHandler adds request-scoped attributes from the context passed to the slog.*Context
+// functions: the request id, trace and span id, route pattern and principal.
+type ContextHandler struct {
+	next slog.Handler
+}
+
+func NewContextHandler(next slog.Handler) *ContextHandler {
+	return &ContextHandler{next: next}
+}
+
+func (h *ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	// Attributes logged explicitly take precedence, so keys aren't duplicated.
+	logged := make(map[string]bool)
+	r.Attrs(func(a slog.Attr) bool {
+		logged[a.Key] = true
+		return true
+	})
+	add := func(key, value string) {
+		if !logged[key] {
+			r.AddAttrs(slog.String(key, value))
+		}
+	}
+
+	if id, ok := RequestIDFromContext(ctx); ok {
+		add(RequestIDKey, id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		add(TraceIDKey, sc.TraceID().String())
+		add(SpanIDKey, sc.SpanID().String())
+	}
+	// The pattern grows while chi routes the request, so it's read when the record is logged.
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			add(RouteKey, pattern)
+		}
+	}
+	if p, ok := auth.FromContext(ctx); ok {
+		add(PrincipalKey, p.ID)
+	} else if subject, ok := auth.SubjectFromContext(ctx); ok {
+		add(PrincipalKey, subject)
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{next: h.next.WithGroup(name)}
+}
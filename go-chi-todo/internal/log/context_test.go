@@ -0,0 +1,99 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+)
+
+func TestContextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewContextHandler(slog.NewJSONHandler(&buf, nil))).With(slog.String("component", "test"))
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+	}))
+	ctx = NewRequestIDContext(ctx, "req-1")
+	ctx = auth.NewContext(ctx, auth.Principal{ID: "alice"})
+	rctx := chi.NewRouteContext()
+	rctx.RoutePatterns = []string{"/todo/{id}"}
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+
+	logger.InfoContext(ctx, "with context")
+	logger.Info("without context")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Want 2 log lines, got %d", len(lines))
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("Error decoding log line: %v", err)
+	}
+	want := map[string]string{
+		RequestIDKey: "req-1",
+		TraceIDKey:   "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanIDKey:    "00f067aa0ba902b7",
+		RouteKey:     "/todo/{id}",
+		PrincipalKey: "alice",
+		"component":  "test",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("Want %s %q, got %v", k, v, got[k])
+		}
+	}
+	if strings.Contains(lines[1], RequestIDKey) {
+		t.Errorf("Want no request attributes without context, got %s", lines[1])
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		generate bool
+	}{
+		{"accepted", "3f2b8c1e-5d7a-4b6e-9c0d-1a2b3c4d5e6f", false},
+		{"missing", "", true},
+		{"too_long", strings.Repeat("a", maxRequestIDLength+1), true},
+		{"forged_fields", "abc\" level=ERROR", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var fromCtx string
+			h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromCtx, _ = RequestIDFromContext(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/todo", nil)
+			if tc.header != "" {
+				r.Header.Set(RequestIDHeader, tc.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			got := w.Header().Get(RequestIDHeader)
+			if got != fromCtx {
+				t.Errorf("Want response header %q to match context %q", got, fromCtx)
+			}
+			if tc.generate && (got == tc.header || len(got) != 32) {
+				t.Errorf("Want generated request id, got %q", got)
+			}
+			if !tc.generate && got != tc.header {
+				t.Errorf("Want request id %q, got %q", tc.header, got)
+			}
+		})
+	}
+}
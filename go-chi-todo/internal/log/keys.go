@@ -1,5 +1,10 @@
 package log
 
 const (
-	ErrorKey = "error"
+	ErrorKey     = "error"
+	RequestIDKey = "requestId"
+	TraceIDKey   = "traceId"
+	SpanIDKey    = "spanId"
+	RouteKey     = "route"
+	PrincipalKey = "principal"
 )
@@ -0,0 +1,175 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Levels holds the level of the structured handler and per-component overrides. A component
+// is the name of the package that logs a record, such as "router" or "postgres".
+type Levels struct {
+	base       slog.LevelVar
+	configured slog.Level
+
+	mu        sync.RWMutex
+	overrides map[string]slog.Level
+	timers    map[string]*time.Timer
+	min       slog.LevelVar
+}
+
+// levels is shared by all handlers created with NewStructured.
+var levels = &Levels{}
+
+// DefaultLevels returns the levels used by NewStructured.
+func DefaultLevels() *Levels {
+	return levels
+}
+
+// Set changes the level of component, or the base level if component is empty. If revertAfter
+// is positive, the change is undone after that duration.
+func (l *Levels) Set(component string, level slog.Level, revertAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if component == "" {
+		l.base.Set(level)
+	} else {
+		if l.overrides == nil {
+			l.overrides = make(map[string]slog.Level)
+		}
+		l.overrides[component] = level
+	}
+	l.updateMin()
+
+	if t, ok := l.timers[component]; ok {
+		t.Stop()
+		delete(l.timers, component)
+	}
+	if revertAfter > 0 {
+		if l.timers == nil {
+			l.timers = make(map[string]*time.Timer)
+		}
+		var t *time.Timer
+		t = time.AfterFunc(revertAfter, func() {
+			// Ignore timers that were replaced while this one fired.
+			l.mu.RLock()
+			current := l.timers[component] == t
+			l.mu.RUnlock()
+			if current {
+				l.Reset(component)
+				slog.Info("reverted log level", slog.String("component", component), slog.String("level", l.Level(component).String()))
+			}
+		})
+		l.timers[component] = t
+	}
+}
+
+// Reset removes the override for component, or restores the configured base level if
+// component is empty.
+func (l *Levels) Reset(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t, ok := l.timers[component]; ok {
+		t.Stop()
+		delete(l.timers, component)
+	}
+	if component == "" {
+		l.base.Set(l.configured)
+	} else {
+		delete(l.overrides, component)
+	}
+	l.updateMin()
+}
+
+// Level returns the effective level for component.
+func (l *Levels) Level(component string) slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if level, ok := l.overrides[component]; ok {
+		return level
+	}
+	return l.base.Level()
+}
+
+// Snapshot returns the base level under "" and all overrides.
+func (l *Levels) Snapshot() map[string]slog.Level {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s := maps.Clone(l.overrides)
+	if s == nil {
+		s = make(map[string]slog.Level)
+	}
+	s[""] = l.base.Level()
+	return s
+}
+
+func (l *Levels) configure(level slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.configured = level
+	l.base.Set(level)
+	l.updateMin()
+}
+
+// updateMin must be called with mu held.
+func (l *Levels) updateMin() {
+	m := l.base.Level()
+	for _, level := range l.overrides {
+		m = min(m, level)
+	}
+	l.min.Set(m)
+}
+
+// ParseLevel parses level names such as "debug" or "WARN" and offsets such as "debug-4".
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// levelHandler filters records by the level of the component that logged them.
+type levelHandler struct {
+	next   slog.Handler
+	levels *Levels
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	// The component is only known once the record exists, so let through anything that
+	// some component might log.
+	return level >= h.levels.min.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.levels.Level(component(r.PC)) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{next: h.next.WithAttrs(attrs), levels: h.levels}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{next: h.next.WithGroup(name), levels: h.levels}
+}
+
+// component returns the package name of the function at pc, e.g. "router" for
+// ".../internal/router.writeError".
+func component(pc uintptr) string {
+	if pc == 0 {
+		return ""
+	}
+	fs := runtime.CallersFrames([]uintptr{pc})
+	f, _ := fs.Next()
+	fn := f.Function[strings.LastIndexByte(f.Function, '/')+1:]
+	name, _, _ := strings.Cut(fn, ".")
+	return name
+}
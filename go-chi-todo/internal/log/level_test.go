@@ -0,0 +1,63 @@
+package log
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+	levels := &Levels{}
+	logger := slog.New(&levelHandler{next: slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), levels: levels})
+
+	tests := []struct {
+		name      string
+		component string
+		level     slog.Level
+		want      bool
+	}{
+		{"default", "", slog.LevelInfo, false},
+		{"base", "", slog.LevelDebug, true},
+		{"this_component", "log", slog.LevelDebug, true},
+		{"other_component", "router", slog.LevelDebug, false},
+		{"component_above_base", "log", slog.LevelWarn, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			levels.Reset("")
+			levels.Reset("log")
+			levels.Reset("router")
+			levels.Set(tc.component, tc.level, 0)
+			buf.Reset()
+			logger.Debug("debug")
+			logger.Info("info")
+			if got := strings.Contains(buf.String(), `"msg":"debug"`); got != tc.want {
+				t.Errorf("Want debug record %v, got %v", tc.want, got)
+			}
+			if got := strings.Contains(buf.String(), `"msg":"info"`); got != (tc.level <= slog.LevelInfo) {
+				t.Errorf("Want info record %v, got %v", tc.level <= slog.LevelInfo, got)
+			}
+		})
+	}
+}
+
+func TestLevelsRevert(t *testing.T) {
+	levels := &Levels{}
+	levels.Set("postgres", slog.LevelDebug, 10*time.Millisecond)
+	if got := levels.Level("postgres"); got != slog.LevelDebug {
+		t.Fatalf("Want level %v, got %v", slog.LevelDebug, got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for levels.Level("postgres") != slog.LevelInfo {
+		if time.Now().After(deadline) {
+			t.Fatal("Want override to be reverted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := levels.Snapshot(); len(got) != 1 {
+		t.Errorf("Want only the base level after revert, got %v", got)
+	}
+}
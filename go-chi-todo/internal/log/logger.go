@@ -3,19 +3,28 @@ package log
 import (
 	"io"
 	"log/slog"
+	"slices"
 )
 
-func NewStructured(w io.Writer, debug bool) *slog.JSONHandler {
+// NewStructured returns a JSON handler whose level can be changed at runtime through
+// DefaultLevels. Values of DefaultRedactedKeys and redactKeys are masked, as are secrets
+// found in string and error values.
+func NewStructured(w io.Writer, debug bool, redactKeys ...string) slog.Handler {
+	redact := redactor(slices.Concat(redactKeys, DefaultRedactedKeys))
 	opts := slog.HandlerOptions{
+		// Filtering is done by levelHandler.
+		Level: slog.LevelDebug - 4,
 		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
 			if a.Key == slog.TimeKey {
 				return slog.Time(a.Key, a.Value.Time().UTC())
 			}
-			return a
+			return redact(groups, a)
 		},
 	}
+	level := slog.LevelInfo
 	if debug {
-		opts.Level = slog.LevelDebug
+		level = slog.LevelDebug
 	}
-	return slog.NewJSONHandler(w, &opts)
+	levels.configure(level)
+	return &levelHandler{next: slog.NewJSONHandler(w, &opts), levels: levels}
 }
@@ -0,0 +1,16 @@
+package model
+
+import "context"
+
+type readYourWritesKey struct{}
+
+// NewReadYourWritesContext requires reads in ctx to observe all previously committed writes,
+// so they must not be served by a lagging read replica.
+func NewReadYourWritesContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesKey{}, true)
+}
+
+func ReadYourWritesFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(readYourWritesKey{}).(bool)
+	return v
+}
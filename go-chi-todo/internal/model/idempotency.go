@@ -0,0 +1,39 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+type IdempotentResponse struct {
+	Status int               `json:"status"`
+	Header map[string]string `json:"header"`
+	Body   []byte            `json:"body"`
+}
+
+type IdempotencyRecord struct {
+	RequestHash []byte
+	// Response is nil while the original request is still being processed.
+	Response *IdempotentResponse
+}
+
+type IdempotencyStore interface {
+	// Reserve claims key for a request. If key has already been claimed and has not expired,
+	// the existing record is returned and reserved is false.
+	Reserve(ctx context.Context, key string, requestHash []byte, ttl time.Duration) (rec IdempotencyRecord, reserved bool, err error)
+	Complete(ctx context.Context, key string, res IdempotentResponse) error
+	Release(ctx context.Context, key string) error
+}
+
+type idempotencyKeyKey struct{}
+
+// NewIdempotencyKeyContext marks the request in ctx as protected by a reserved idempotency key,
+// which allows stores to safely retry creating resources.
+func NewIdempotencyKeyContext(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyKey{}).(string)
+	return key, ok
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"context"
+	"strings"
+)
+
+// List groups todos, so they can be shared together. Anyone a list is shared with can see all
+// of its todos, and list editors can change them.
+type List struct {
+	Id   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+const MaxListNameLength = 255
+
+// Normalize trims leading and trailing white space. It should be called before Validate.
+func (l *List) Normalize() {
+	l.Name = strings.TrimSpace(l.Name)
+}
+
+func (l List) Validate() error {
+	var v Validator
+	v.Required("name", l.Name)
+	v.MaxLength("name", l.Name, MaxListNameLength)
+	v.Text("name", l.Name, false)
+	return v.Err()
+}
+
+// ListStore manages lists and who they are shared with. Like CollaboratorStore, it takes the
+// caller from the context and reports lists the caller cannot see as ErrEmptyResultSet.
+type ListStore interface {
+	Lists(ctx context.Context, offset int, limit int) ([]List, error)
+	FindList(ctx context.Context, id int) (List, error)
+	CreateList(ctx context.Context, l List) (List, error)
+	DeleteList(ctx context.Context, id int) error
+	ListTodos(ctx context.Context, listID int, offset int, limit int) ([]Todo, error)
+	ListCollaborators(ctx context.Context, listID int) ([]Collaborator, error)
+	GrantList(ctx context.Context, listID int, c Collaborator) error
+	RevokeList(ctx context.Context, listID int, principal string) error
+}
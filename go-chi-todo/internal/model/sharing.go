@@ -0,0 +1,42 @@
+package model
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrForbidden = errors.New("caller is not allowed to perform this operation")
+
+// ErrUnauthenticated reports that an operation requires a verified principal.
+var ErrUnauthenticated = errors.New("caller is not authenticated")
+
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleEditor Role = "editor"
+	RoleViewer Role = "viewer"
+)
+
+type Collaborator struct {
+	Principal string `json:"principal"`
+	Role      Role   `json:"role"`
+}
+
+func (c Collaborator) Validate() error {
+	var v Validator
+	v.Required("principal", c.Principal)
+	v.MaxLength("principal", c.Principal, 255)
+	v.Text("principal", c.Principal, false)
+	v.Check(c.Role == RoleViewer || c.Role == RoleEditor, "role", `must be "viewer" or "editor"`)
+	return v.Err()
+}
+
+// CollaboratorStore manages who a todo is shared with. The caller is taken from the
+// context. Items the caller cannot see are reported as ErrEmptyResultSet, items the
+// caller can see but not modify as ErrForbidden.
+type CollaboratorStore interface {
+	Collaborators(ctx context.Context, todoID int) ([]Collaborator, error)
+	Grant(ctx context.Context, todoID int, c Collaborator) error
+	Revoke(ctx context.Context, todoID int, principal string) error
+}
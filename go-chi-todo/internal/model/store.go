@@ -3,15 +3,54 @@ package model
 import (
 	"context"
 	"errors"
+	"strings"
+	"time"
 )
 
 var ErrEmptyResultSet = errors.New("query or statement produced empty result")
 
+// ErrTimeout reports that the database cancelled a statement because it exceeded its time limit.
+var ErrTimeout = errors.New("statement timed out")
+
+// UnavailableError reports that a store refuses requests for now, e.g. while its database is down.
+type UnavailableError struct {
+	Reason string
+	// RetryAfter is zero if it is unknown when the store becomes available again.
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return "store unavailable: " + e.Reason
+}
+
 type Todo struct {
 	Id          int64  `json:"id"`
 	Description string `json:"description"`
 	Details     string `json:"details"`
 	Done        bool   `json:"done"`
+	// ListID is set when the todo is created. Updates keep the todo in its list.
+	ListID *int64 `json:"listId,omitempty"`
+}
+
+const (
+	MaxDescriptionLength = 255
+	MaxDetailsLength     = 255
+)
+
+// Normalize trims leading and trailing white space. It should be called before Validate.
+func (t *Todo) Normalize() {
+	t.Description = strings.TrimSpace(t.Description)
+	t.Details = strings.TrimSpace(t.Details)
+}
+
+func (t Todo) Validate() error {
+	var v Validator
+	v.Required("description", t.Description)
+	v.MaxLength("description", t.Description, MaxDescriptionLength)
+	v.Text("description", t.Description, false)
+	v.MaxLength("details", t.Details, MaxDetailsLength)
+	v.Text("details", t.Details, true)
+	return v.Err()
 }
 
 type TodoStore interface {
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError reports all invalid fields of a model at once.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Validator collects field errors. The zero value is ready to use.
+type Validator struct {
+	errs []FieldError
+}
+
+func (v *Validator) Add(field string, msg string) {
+	v.errs = append(v.errs, FieldError{Field: field, Message: msg})
+}
+
+func (v *Validator) Check(ok bool, field string, msg string) {
+	if !ok {
+		v.Add(field, msg)
+	}
+}
+
+func (v *Validator) Required(field string, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "must not be blank")
+}
+
+// MaxLength checks the length in characters, which is how Postgres measures varchar(n).
+func (v *Validator) MaxLength(field string, value string, n int) {
+	v.Check(utf8.RuneCountInString(value) <= n, field, fmt.Sprintf("must not be longer than %d characters", n))
+}
+
+// Text rejects invalid UTF-8 and control characters. Multi-line text may contain
+// line breaks and tabs.
+func (v *Validator) Text(field string, value string, multiline bool) {
+	if !utf8.ValidString(value) {
+		v.Add(field, "must be valid UTF-8")
+		return
+	}
+	for _, r := range value {
+		if multiline && (r == '\n' || r == '\r' || r == '\t') {
+			continue
+		}
+		if unicode.IsControl(r) {
+			v.Add(field, "must not contain control characters")
+			return
+		}
+	}
+}
+
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return &ValidationError{Errors: v.errs}
+}
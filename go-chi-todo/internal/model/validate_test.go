@@ -0,0 +1,91 @@
+package model
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestTodoValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		item   Todo
+		fields []string
+	}{
+		{
+			name: "valid",
+			item: Todo{Description: "test", Details: "a test"},
+		},
+		{
+			name: "valid_multiline_details",
+			item: Todo{Description: "test", Details: "line 1\n\tline 2\r\n"},
+		},
+		{
+			name: "valid_max_length_multibyte",
+			item: Todo{Description: strings.Repeat("ü", MaxDescriptionLength)},
+		},
+		{
+			name:   "blank_description",
+			item:   Todo{Description: "  \t"},
+			fields: []string{"description"},
+		},
+		{
+			name:   "description_too_long",
+			item:   Todo{Description: strings.Repeat("a", MaxDescriptionLength+1)},
+			fields: []string{"description"},
+		},
+		{
+			name:   "description_newline",
+			item:   Todo{Description: "a\nb"},
+			fields: []string{"description"},
+		},
+		{
+			name:   "details_control_character",
+			item:   Todo{Description: "test", Details: "a\x00b"},
+			fields: []string{"details"},
+		},
+		{
+			name:   "details_invalid_utf8",
+			item:   Todo{Description: "test", Details: "a\xffb"},
+			fields: []string{"details"},
+		},
+		{
+			name:   "multiple_fields",
+			item:   Todo{Description: "", Details: strings.Repeat("a", MaxDetailsLength+1)},
+			fields: []string{"description", "details"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.item.Validate()
+			if len(tc.fields) == 0 {
+				if err != nil {
+					t.Fatalf("Want no error, got %v", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Want *ValidationError, got %v", err)
+			}
+			var fields []string
+			for _, fe := range verr.Errors {
+				if !slices.Contains(fields, fe.Field) {
+					fields = append(fields, fe.Field)
+				}
+			}
+			if !slices.Equal(fields, tc.fields) {
+				t.Errorf("Want invalid fields %v, got %v", tc.fields, fields)
+			}
+		})
+	}
+}
+
+func TestTodoNormalize(t *testing.T) {
+	item := Todo{Description: "  test \n", Details: "\tdetails  "}
+	item.Normalize()
+	if item.Description != "test" || item.Details != "details" {
+		t.Errorf("Want trimmed fields, got %q and %q", item.Description, item.Details)
+	}
+}
@@ -0,0 +1,217 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/envelope"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// Additional authenticated data binds ciphertexts to their column and row, so they can't be
+// swapped between columns or rows.
+func detailsAAD(id int64) []byte {
+	return fmt.Appendf(nil, "todo.details:%d", id)
+}
+
+func bodyAAD(key string) []byte {
+	return []byte("idempotency_key.body:" + key)
+}
+
+var errNoEncryption = errors.New("encryption is not configured")
+
+// todoColumns are the columns scanned by scanTodo.
+const todoColumns = `t.id, t.description, t.details, t.details_ciphertext, t.details_key, t.details_key_version, t.done, t.list_id`
+
+// sealed is how an optionally encrypted value is stored: either plaintext, or ciphertext
+// with its wrapped key and key version.
+type sealed struct {
+	plaintext  *string
+	ciphertext []byte
+	key        []byte
+	version    *string
+}
+
+func (ts *TodoStore) seal(ctx context.Context, value string, aad []byte) (sealed, error) {
+	if ts.enc == nil {
+		return sealed{plaintext: &value}, nil
+	}
+	s, err := ts.enc.Seal(ctx, []byte(value), aad)
+	if err != nil {
+		return sealed{}, err
+	}
+	return sealed{ciphertext: s.Ciphertext, key: s.WrappedKey, version: &s.KeyVersion}, nil
+}
+
+func (ts *TodoStore) open(ctx context.Context, s sealed, aad []byte) (string, error) {
+	if s.version == nil {
+		if s.plaintext == nil {
+			return "", nil
+		}
+		return *s.plaintext, nil
+	}
+	if ts.enc == nil {
+		return "", errNoEncryption
+	}
+	b, err := ts.enc.Open(ctx, envelope.Sealed{Ciphertext: s.ciphertext, WrappedKey: s.key, KeyVersion: *s.version}, aad)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (ts *TodoStore) scanTodo(ctx context.Context) pgx.RowToFunc[model.Todo] {
+	return func(row pgx.CollectableRow) (model.Todo, error) {
+		var item model.Todo
+		var s sealed
+		if err := row.Scan(&item.Id, &item.Description, &s.plaintext, &s.ciphertext, &s.key, &s.version, &item.Done, &item.ListID); err != nil {
+			return item, err
+		}
+		details, err := ts.open(ctx, s, detailsAAD(item.Id))
+		if err != nil {
+			return item, err
+		}
+		item.Details = details
+		return item, nil
+	}
+}
+
+// Reencrypt encrypts plaintext details and rewraps details encrypted with an older key version
+// using the current key version, in batches of batchSize rows. It returns the number of rows
+// updated. Rows modified concurrently are skipped and picked up by the next run.
+//
+// Idempotent responses are not rewrapped. Keep retired key versions in the keyring until
+// the idempotency TTL has passed since rotation.
+func (ts *TodoStore) Reencrypt(ctx context.Context, batchSize int) (int, error) {
+	if ts.enc == nil {
+		return 0, errNoEncryption
+	}
+	current, err := ts.enc.CurrentVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct {
+		id int64
+		sealed
+	}
+	var total int
+	var lastID int64
+	for {
+		rows, err := ts.pool.Query(
+			ctx,
+			`SELECT id, details, details_ciphertext, details_key, details_key_version FROM todo
+			WHERE id > $1 AND details_key_version IS DISTINCT FROM $2 AND (details IS NOT NULL OR details_key_version IS NOT NULL)
+			ORDER BY id LIMIT $3`,
+			lastID, current, batchSize)
+		if err != nil {
+			return total, err
+		}
+		batch, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pending, error) {
+			var p pending
+			err := row.Scan(&p.id, &p.plaintext, &p.ciphertext, &p.key, &p.version)
+			return p, err
+		})
+		if err != nil {
+			return total, err
+		}
+
+		for _, p := range batch {
+			lastID = p.id
+			var next sealed
+			if p.version == nil {
+				if next, err = ts.seal(ctx, *p.plaintext, detailsAAD(p.id)); err != nil {
+					return total, err
+				}
+			} else {
+				s, _, err := ts.enc.Rewrap(ctx, envelope.Sealed{Ciphertext: p.ciphertext, WrappedKey: p.key, KeyVersion: *p.version})
+				if err != nil {
+					return total, err
+				}
+				next = sealed{ciphertext: s.Ciphertext, key: s.WrappedKey, version: &s.KeyVersion}
+			}
+			swapped, err := ts.swapDetails(ctx, p.id, p.sealed, next)
+			if err != nil {
+				return total, err
+			}
+			if swapped {
+				total++
+			}
+		}
+
+		if len(batch) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// Decrypt replaces encrypted details by their plaintext in batches of batchSize rows and
+// returns the number of rows updated. Rows modified concurrently are skipped and picked up by
+// the next run. Run it before migrating below the version that added encryption.
+func (ts *TodoStore) Decrypt(ctx context.Context, batchSize int) (int, error) {
+	if ts.enc == nil {
+		return 0, errNoEncryption
+	}
+
+	type pending struct {
+		id      int64
+		details string
+		sealed
+	}
+	var total int
+	var lastID int64
+	for {
+		rows, err := ts.pool.Query(
+			ctx,
+			`SELECT id, details, details_ciphertext, details_key, details_key_version FROM todo
+			WHERE id > $1 AND details_key_version IS NOT NULL ORDER BY id LIMIT $2`,
+			lastID, batchSize)
+		if err != nil {
+			return total, err
+		}
+		batch, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pending, error) {
+			var p pending
+			if err := row.Scan(&p.id, &p.plaintext, &p.ciphertext, &p.key, &p.version); err != nil {
+				return p, err
+			}
+			p.details, err = ts.open(ctx, p.sealed, detailsAAD(p.id))
+			return p, err
+		})
+		if err != nil {
+			return total, err
+		}
+
+		for _, p := range batch {
+			lastID = p.id
+			swapped, err := ts.swapDetails(ctx, p.id, p.sealed, sealed{plaintext: &p.details})
+			if err != nil {
+				return total, err
+			}
+			if swapped {
+				total++
+			}
+		}
+
+		if len(batch) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// swapDetails replaces the details of todo id by next, unless they no longer match prev as read.
+// Comparing the ciphertext and wrapped key too catches updates sealed with the same key version.
+func (ts *TodoStore) swapDetails(ctx context.Context, id int64, prev, next sealed) (bool, error) {
+	tag, err := ts.pool.Exec(
+		ctx,
+		`UPDATE todo SET details = $2, details_ciphertext = $3, details_key = $4, details_key_version = $5
+		WHERE id = $1 AND details IS NOT DISTINCT FROM $6 AND details_ciphertext IS NOT DISTINCT FROM $7
+		AND details_key IS NOT DISTINCT FROM $8 AND details_key_version IS NOT DISTINCT FROM $9`,
+		id, next.plaintext, next.ciphertext, next.key, next.version, prev.plaintext, prev.ciphertext, prev.key, prev.version)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
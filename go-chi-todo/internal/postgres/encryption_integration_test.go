@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/envelope"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+func TestSwapDetailsConcurrentUpdateTodo(t *testing.T) {
+	ctx := context.Background()
+	scripts, err := filepath.Glob(filepath.Join("..", "..", "migrations", "*.up.sql"))
+	if err != nil {
+		t.Fatalf("failed to list migrations: %v", err)
+	}
+	pgContainer, err := postgres.Run(ctx, "postgres:16-alpine",
+		postgres.WithDatabase("todo-test"), postgres.WithUsername("postgres"),
+		postgres.WithPassword("postgres"), postgres.WithOrderedInitScripts(scripts...),
+		testcontainers.WithWaitStrategy(
+			wait.ForLog("database system is ready to accept connections").WithOccurrence(2).WithStartupTimeout(5*time.Second)))
+	if err != nil {
+		t.Fatalf("failed to initialize Postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Errorf("failed to terminate Postgres container: %v", err)
+		}
+	})
+
+	connStr, err := pgContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	newStore := func(current string, keys map[string][]byte) *TodoStore {
+		kr, err := envelope.NewKeyring(current, keys)
+		if err != nil {
+			t.Fatalf("failed to create keyring: %v", err)
+		}
+		ts, err := NewStore(ctx, connStr, WithEncryption(envelope.New(kr)))
+		if err != nil {
+			t.Fatalf("failed to create TodoStore: %v", err)
+		}
+		t.Cleanup(func() {
+			ts.Close(ctx)
+		})
+		return ts
+	}
+	// An instance still sealing with v1 keeps updating while v2 rewraps.
+	v1 := newStore("v1", map[string][]byte{"v1": k1})
+	v2 := newStore("v2", map[string][]byte{"v1": k1, "v2": k2})
+
+	created, err := v1.Create(ctx, model.Todo{Description: "Encrypted", Details: "before"})
+	if err != nil {
+		t.Fatalf("failed to create todo: %v", err)
+	}
+	var read sealed
+	err = v2.pool.QueryRow(ctx,
+		`SELECT details, details_ciphertext, details_key, details_key_version FROM todo WHERE id = $1`,
+		created.Id).Scan(&read.plaintext, &read.ciphertext, &read.key, &read.version)
+	if err != nil {
+		t.Fatalf("failed to read todo: %v", err)
+	}
+
+	created.Details = "after"
+	if _, err := v1.Update(ctx, created); err != nil {
+		t.Fatalf("failed to update todo: %v", err)
+	}
+
+	rewrapped, _, err := v2.enc.Rewrap(ctx, envelope.Sealed{Ciphertext: read.ciphertext, WrappedKey: read.key, KeyVersion: *read.version})
+	if err != nil {
+		t.Fatalf("failed to rewrap details: %v", err)
+	}
+	plaintext := "before"
+	tests := []struct {
+		name string
+		next sealed
+	}{
+		{name: "reencrypt", next: sealed{ciphertext: rewrapped.Ciphertext, key: rewrapped.WrappedKey, version: &rewrapped.KeyVersion}},
+		{name: "decrypt", next: sealed{plaintext: &plaintext}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			swapped, err := v2.swapDetails(ctx, created.Id, read, tc.next)
+			if err != nil {
+				t.Fatalf("Want no error, got %v", err)
+			}
+			if swapped {
+				t.Error("Want stale details not to be swapped, got swapped")
+			}
+			got, err := v2.Find(ctx, int(created.Id))
+			if err != nil {
+				t.Fatalf("failed to find todo: %v", err)
+			}
+			if got.Details != "after" {
+				t.Errorf("Want details %q, got %q", "after", got.Details)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/envelope"
+)
+
+func TestSealBindsRow(t *testing.T) {
+	kr, err := envelope.NewKeyring("v1", map[string][]byte{"v1": bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("Fatal error creating keyring: %v", err)
+	}
+	ts := &TodoStore{enc: envelope.New(kr)}
+	ctx := context.Background()
+
+	s, err := ts.seal(ctx, "secret details", detailsAAD(1))
+	if err != nil {
+		t.Fatalf("Want no error, got %v", err)
+	}
+	if got, err := ts.open(ctx, s, detailsAAD(1)); err != nil || got != "secret details" {
+		t.Errorf("Want %q, got %q and error %v", "secret details", got, err)
+	}
+	if _, err := ts.open(ctx, s, detailsAAD(2)); !errors.Is(err, envelope.ErrDecrypt) {
+		t.Errorf("Want ErrDecrypt for another row, got %v", err)
+	}
+	if _, err := ts.open(ctx, s, bodyAAD("1")); !errors.Is(err, envelope.ErrDecrypt) {
+		t.Errorf("Want ErrDecrypt for another column, got %v", err)
+	}
+}
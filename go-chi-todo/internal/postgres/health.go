@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/health"
+)
+
+// SchemaVersion is the migration version this build of the store expects.
+const SchemaVersion = 8
+
+// RegisterChecks registers the store's health checks with r.
+func (ts *TodoStore) RegisterChecks(r *health.Registry) {
+	r.Register("database", ts.Ping)
+	// The schema only changes on deployments, so it's checked less frequently.
+	r.Register("migrations", ts.checkMigrations, health.WithCacheTTL(time.Minute))
+	if ts.tokens != nil {
+		r.Register("token", func(ctx context.Context) error {
+			return checkToken(ts.TokenStats(), time.Now())
+		})
+	}
+	if ts.replica != nil {
+		r.Register("replica", func(ctx context.Context) error {
+			status, _ := ts.ReplicaStatus()
+			return checkReplicaStatus(status, ts.replicaMaxLag)
+		})
+	}
+}
+
+// checkMigrations compares the version recorded by golang-migrate with SchemaVersion.
+func (ts *TodoStore) checkMigrations(ctx context.Context) error {
+	var version int64
+	var dirty bool
+	err := ts.pool.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errors.New("no migrations have been applied")
+	}
+	if err != nil {
+		return fmt.Errorf("reading schema version: %w", err)
+	}
+	return checkSchemaVersion(version, dirty)
+}
+
+func checkSchemaVersion(version int64, dirty bool) error {
+	switch {
+	case dirty:
+		return fmt.Errorf("migration %d failed and left the schema dirty", version)
+	case version < SchemaVersion:
+		return fmt.Errorf("schema version is %d, want %d", version, SchemaVersion)
+	case version > SchemaVersion:
+		// Newer migrations must stay compatible with running instances during a rollout.
+		return health.Warnf("schema version is %d, newer than %d", version, SchemaVersion)
+	}
+	return nil
+}
+
+// checkToken fails if the database token has expired, and warns if it's about to expire or
+// the last refresh failed. Existing connections keep working until they're recycled.
+func checkToken(stats TokenStats, now time.Time) error {
+	switch {
+	case stats.ExpiresOn.IsZero():
+		return errors.New("no token has been acquired")
+	case !now.Before(stats.ExpiresOn):
+		return fmt.Errorf("token expired at %s", stats.ExpiresOn.Format(time.RFC3339))
+	case stats.ConsecutiveFailures > 0:
+		return health.Warnf("%d token refreshes failed, token expires at %s", stats.ConsecutiveFailures, stats.ExpiresOn.Format(time.RFC3339))
+	case stats.ExpiresOn.Sub(now) < refreshSkew:
+		return health.Warnf("token expires at %s", stats.ExpiresOn.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkReplicaStatus only warns, since reads fall back to the primary.
+func checkReplicaStatus(status ReplicaStatus, maxLag time.Duration) error {
+	switch {
+	case !status.Healthy:
+		return health.Warnf("replica is unhealthy, reads use the primary")
+	case status.Lag > maxLag:
+		return health.Warnf("replica lag %s exceeds %s, reads use the primary", status.Lag, maxLag)
+	}
+	return nil
+}
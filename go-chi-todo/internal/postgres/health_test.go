@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/health"
+)
+
+// status maps a check error to the status it's reported with.
+func status(err error) health.Status {
+	r := health.New()
+	r.Register("check", func(ctx context.Context) error { return err })
+	return r.Run(context.Background()).Status
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int64
+		dirty   bool
+		want    health.Status
+	}{
+		{"current", SchemaVersion, false, health.Pass},
+		{"dirty", SchemaVersion, true, health.Fail},
+		{"older", SchemaVersion - 1, false, health.Fail},
+		{"newer", SchemaVersion + 1, false, health.Warn},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := status(checkSchemaVersion(tc.version, tc.dirty)); got != tc.want {
+				t.Errorf("Want status %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		stats TokenStats
+		want  health.Status
+	}{
+		{"valid", TokenStats{ExpiresOn: now.Add(time.Hour)}, health.Pass},
+		{"none", TokenStats{}, health.Fail},
+		{"expired", TokenStats{ExpiresOn: now.Add(-time.Second)}, health.Fail},
+		{"expiring", TokenStats{ExpiresOn: now.Add(time.Minute)}, health.Warn},
+		{"refresh_failed", TokenStats{ExpiresOn: now.Add(time.Hour), ConsecutiveFailures: 2}, health.Warn},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := status(checkToken(tc.stats, now)); got != tc.want {
+				t.Errorf("Want status %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckReplicaStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status ReplicaStatus
+		want   health.Status
+	}{
+		{"healthy", ReplicaStatus{Healthy: true, Lag: time.Second}, health.Pass},
+		{"unhealthy", ReplicaStatus{}, health.Warn},
+		{"lagging", ReplicaStatus{Healthy: true, Lag: time.Minute}, health.Warn},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := status(checkReplicaStatus(tc.status, 30*time.Second)); got != tc.want {
+				t.Errorf("Want status %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
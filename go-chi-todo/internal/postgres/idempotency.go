@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// inFlightLease is how long a reservation without a response blocks other requests
+// with the same key. It allows retries to take over if a replica crashed mid-request.
+const inFlightLease = time.Minute
+
+var _ model.IdempotencyStore = (*TodoStore)(nil)
+
+func (ts *TodoStore) Reserve(ctx context.Context, key string, requestHash []byte, ttl time.Duration) (model.IdempotencyRecord, bool, error) {
+	// A concurrent Release may delete the conflicting row before we read it, so try again.
+	for range 3 {
+		var reserved int
+		// A retried reservation would conflict with itself, so only retry if it wasn't sent.
+		// Todos created with an expired key are detached from it, so creating a todo with the
+		// reused key doesn't return the old one. Stale in-flight reservations keep their todo,
+		// because the request taking over is a retry of the original one.
+		err := ts.retry.do(ctx, false, func(ctx context.Context) error {
+			return ts.pool.QueryRow(
+				ctx,
+				`WITH prior AS (SELECT expires_at < now() AS expired FROM idempotency_key WHERE key = $1),
+				reserved AS (
+					INSERT INTO idempotency_key (key, request_hash, expires_at) VALUES ($1, $2, now() + make_interval(secs => $3))
+					ON CONFLICT (key) DO UPDATE
+					SET request_hash = EXCLUDED.request_hash, status_code = NULL, headers = NULL, body = NULL, body_key = NULL, body_key_version = NULL, created_at = now(), expires_at = EXCLUDED.expires_at
+					WHERE idempotency_key.expires_at < now() OR (idempotency_key.status_code IS NULL AND idempotency_key.created_at < now() - make_interval(secs => $4))
+					RETURNING key),
+				detached AS (UPDATE todo SET request_key = NULL FROM prior, reserved WHERE prior.expired AND todo.request_key = reserved.key)
+				SELECT count(*) FROM reserved`,
+				key, requestHash, ttl.Seconds(), inFlightLease.Seconds()).Scan(&reserved)
+		})
+		if err != nil {
+			return model.IdempotencyRecord{}, false, err
+		}
+		if reserved == 1 {
+			return model.IdempotencyRecord{RequestHash: requestHash}, true, nil
+		}
+
+		var rec model.IdempotencyRecord
+		var status *int
+		var res model.IdempotentResponse
+		var body sealed
+		err = ts.retry.do(ctx, true, func(ctx context.Context) error {
+			return ts.pool.QueryRow(
+				ctx,
+				`SELECT request_hash, status_code, headers, body, body_key, body_key_version FROM idempotency_key WHERE key = $1`,
+				key).Scan(&rec.RequestHash, &status, &res.Header, &body.ciphertext, &body.key, &body.version)
+		})
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return model.IdempotencyRecord{}, false, err
+		}
+		if status != nil {
+			if body.version == nil {
+				res.Body = body.ciphertext
+			} else {
+				b, err := ts.open(ctx, body, bodyAAD(key))
+				if err != nil {
+					return model.IdempotencyRecord{}, false, err
+				}
+				res.Body = []byte(b)
+			}
+			res.Status = *status
+			rec.Response = &res
+		}
+		return rec, false, nil
+	}
+	return model.IdempotencyRecord{}, false, errors.New("reserving idempotency key: too much contention")
+}
+
+func (ts *TodoStore) Complete(ctx context.Context, key string, res model.IdempotentResponse) error {
+	// Responses contain todo details, so they're encrypted like the todos themselves.
+	body := sealed{ciphertext: res.Body}
+	if ts.enc != nil {
+		var err error
+		if body, err = ts.seal(ctx, string(res.Body), bodyAAD(key)); err != nil {
+			return err
+		}
+	}
+	return ts.retry.do(ctx, true, func(ctx context.Context) error {
+		tag, err := ts.pool.Exec(
+			ctx,
+			`UPDATE idempotency_key SET status_code = $2, headers = $3, body = $4, body_key = $5, body_key_version = $6 WHERE key = $1`,
+			key, res.Status, res.Header, body.ciphertext, body.key, body.version)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return model.ErrEmptyResultSet
+		}
+		return nil
+	})
+}
+
+func (ts *TodoStore) Release(ctx context.Context, key string) error {
+	return ts.retry.do(ctx, true, func(ctx context.Context) error {
+		_, err := ts.pool.Exec(
+			ctx,
+			`DELETE FROM idempotency_key WHERE key = $1 AND status_code IS NULL`,
+			key)
+		return err
+	})
+}
+
+// PurgeIdempotencyKeys deletes all expired idempotency keys and returns how many were removed.
+func (ts *TodoStore) PurgeIdempotencyKeys(ctx context.Context) (int64, error) {
+	var n int64
+	err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+		tag, err := ts.pool.Exec(ctx, `DELETE FROM idempotency_key WHERE expires_at < now()`)
+		n = tag.RowsAffected()
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,239 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// Access rules for lists. Lists are visible to their owner and anyone they have been shared
+// with, and editable by their owner and editors. Editing a list means adding todos to it and
+// changing the todos in it. Queries using these predicates must alias todo_list as l and pass
+// the caller's principal ID as $1.
+const (
+	canViewList = `(l.owner = $1 OR EXISTS (SELECT 1 FROM todo_list_acl la WHERE la.list_id = l.id AND la.principal = $1))`
+	canEditList = `(l.owner = $1 OR EXISTS (SELECT 1 FROM todo_list_acl la WHERE la.list_id = l.id AND la.principal = $1 AND la.role = 'editor'))`
+)
+
+var _ model.ListStore = (*TodoStore)(nil)
+
+// listAccess reports whether the caller can see the list and whether the caller owns it.
+func (ts *TodoStore) listAccess(ctx context.Context, id int) (visible bool, owner bool, err error) {
+	err = ts.pool.QueryRow(
+		ctx,
+		`SELECT COALESCE(l.owner = $1, false) FROM todo_list l WHERE l.id = $2 AND `+canViewList,
+		caller(ctx),
+		int64(id)).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, owner, nil
+}
+
+// listDeniedOrMissing explains why a todo could not be added to a list. Lists the caller
+// cannot see are reported as an invalid listId, so their existence is not disclosed.
+func (ts *TodoStore) listDeniedOrMissing(ctx context.Context, id int) error {
+	visible, _, err := ts.listAccess(ctx, id)
+	if err != nil {
+		return err
+	}
+	if visible {
+		return model.ErrForbidden
+	}
+	var v model.Validator
+	v.Add("listId", "must refer to an existing list")
+	return v.Err()
+}
+
+func (ts *TodoStore) Lists(ctx context.Context, offset int, limit int) ([]model.List, error) {
+	var lists []model.List
+	err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+		pool := ts.reader(ctx)
+		rows, err := pool.Query(
+			ctx,
+			`SELECT l.id, l.name FROM todo_list l WHERE `+canViewList+` ORDER BY l.name, l.id OFFSET $2 LIMIT $3`,
+			caller(ctx),
+			int64(offset),
+			int64(limit))
+		if err == nil {
+			lists, err = pgx.CollectRows(rows, pgx.RowToStructByPos[model.List])
+		}
+		ts.observeRead(ctx, pool, err)
+		return err
+	})
+	return lists, err
+}
+
+func (ts *TodoStore) FindList(ctx context.Context, id int) (model.List, error) {
+	var l model.List
+	err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+		pool := ts.reader(ctx)
+		rows, err := pool.Query(
+			ctx,
+			`SELECT l.id, l.name FROM todo_list l WHERE l.id = $2 AND `+canViewList,
+			caller(ctx),
+			int64(id))
+		if err == nil {
+			l, err = pgx.CollectOneRow(rows, pgx.RowToStructByPos[model.List])
+		}
+		ts.observeRead(ctx, pool, err)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.List{}, model.ErrEmptyResultSet
+	}
+	return l, err
+}
+
+// CreateList creates a list owned by the caller. Lists can't be created anonymously.
+func (ts *TodoStore) CreateList(ctx context.Context, l model.List) (model.List, error) {
+	l.Normalize()
+	if err := l.Validate(); err != nil {
+		return l, err
+	}
+	owner := caller(ctx)
+	if owner == nil {
+		return l, model.ErrUnauthenticated
+	}
+	err := ts.retry.do(ctx, false, func(ctx context.Context) error {
+		return ts.pool.QueryRow(
+			ctx,
+			`INSERT INTO todo_list (name, owner) VALUES ($1, $2) RETURNING id`,
+			l.Name,
+			*owner).Scan(&l.Id)
+	})
+	return l, err
+}
+
+// DeleteList deletes a list and all todos in it. Only the owner can delete a list.
+func (ts *TodoStore) DeleteList(ctx context.Context, id int) error {
+	return ts.retry.remove(ctx, func(ctx context.Context) error {
+		tag, err := ts.pool.Exec(
+			ctx,
+			`DELETE FROM todo_list l WHERE l.id = $2 AND l.owner = $1`,
+			caller(ctx),
+			int64(id))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() > 0 {
+			return nil
+		}
+		visible, _, err := ts.listAccess(ctx, id)
+		if err != nil {
+			return err
+		}
+		if visible {
+			return model.ErrForbidden
+		}
+		return model.ErrEmptyResultSet
+	})
+}
+
+// ListTodos returns the todos in a list. Everyone who can see a list can see all of its todos.
+func (ts *TodoStore) ListTodos(ctx context.Context, listID int, offset int, limit int) ([]model.Todo, error) {
+	if _, err := ts.FindList(ctx, listID); err != nil {
+		return nil, err
+	}
+	var items []model.Todo
+	err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+		pool := ts.reader(ctx)
+		rows, err := pool.Query(
+			ctx,
+			`SELECT `+todoColumns+` FROM todo t WHERE t.list_id = $2 AND `+canView+` ORDER BY t.description OFFSET $3 LIMIT $4`,
+			caller(ctx),
+			int64(listID),
+			int64(offset),
+			int64(limit))
+		if err == nil {
+			items, err = pgx.CollectRows(rows, ts.scanTodo(ctx))
+		}
+		ts.observeRead(ctx, pool, err)
+		return err
+	})
+	return items, err
+}
+
+func (ts *TodoStore) ListCollaborators(ctx context.Context, listID int) ([]model.Collaborator, error) {
+	var collaborators []model.Collaborator
+	err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+		rows, err := ts.pool.Query(
+			ctx,
+			`SELECT l.owner, a.principal, a.role FROM todo_list l LEFT JOIN todo_list_acl a ON a.list_id = l.id
+			WHERE l.id = $2 AND `+canViewList+` ORDER BY a.principal`,
+			caller(ctx),
+			int64(listID))
+		if err != nil {
+			return err
+		}
+		collaborators, err = collectCollaborators(rows)
+		return err
+	})
+	return collaborators, err
+}
+
+func (ts *TodoStore) GrantList(ctx context.Context, listID int, c model.Collaborator) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	return ts.retry.do(ctx, true, func(ctx context.Context) error {
+		tag, err := ts.pool.Exec(
+			ctx,
+			`INSERT INTO todo_list_acl (list_id, principal, role) SELECT l.id, $3, $4 FROM todo_list l WHERE l.id = $2 AND l.owner = $1
+			ON CONFLICT (list_id, principal) DO UPDATE SET role = EXCLUDED.role, granted_at = now()`,
+			caller(ctx),
+			int64(listID),
+			c.Principal,
+			string(c.Role))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() > 0 {
+			return nil
+		}
+		visible, _, err := ts.listAccess(ctx, listID)
+		if err != nil {
+			return err
+		}
+		if visible {
+			return model.ErrForbidden
+		}
+		return model.ErrEmptyResultSet
+	})
+}
+
+// RevokeList removes a collaborator. Owners can revoke anyone, collaborators can only remove
+// themselves.
+func (ts *TodoStore) RevokeList(ctx context.Context, listID int, principal string) error {
+	return ts.retry.remove(ctx, func(ctx context.Context) error {
+		tag, err := ts.pool.Exec(
+			ctx,
+			`DELETE FROM todo_list_acl a USING todo_list l WHERE a.list_id = l.id AND l.id = $2 AND a.principal = $3 AND (l.owner = $1 OR a.principal = $1)`,
+			caller(ctx),
+			int64(listID),
+			principal)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() > 0 {
+			return nil
+		}
+		visible, owner, err := ts.listAccess(ctx, listID)
+		if err != nil {
+			return err
+		}
+		if !visible || owner {
+			return model.ErrEmptyResultSet
+		}
+		if p := caller(ctx); p != nil && *p == principal {
+			return model.ErrEmptyResultSet
+		}
+		return model.ErrForbidden
+	})
+}
@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func poolDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc("todo_db_pool_"+name, help, []string{"pool"}, nil)
+}
+
+var (
+	poolAcquiredConns = poolDesc("acquired_conns", "Number of connections currently in use.")
+	poolIdleConns     = poolDesc("idle_conns", "Number of idle connections.")
+	poolTotalConns    = poolDesc("total_conns", "Number of connections currently open.")
+	poolMaxConns      = poolDesc("max_conns", "Maximum size of the pool.")
+	poolAcquires      = poolDesc("acquires_total", "Number of successful connection acquires.")
+	poolAcquireTime   = poolDesc("acquire_duration_seconds_total", "Time spent acquiring connections.")
+	poolEmptyAcquires = poolDesc("empty_acquires_total", "Number of acquires that had to wait for a connection.")
+	poolCanceled      = poolDesc("canceled_acquires_total", "Number of acquires canceled by their context.")
+	poolNewConns      = poolDesc("new_conns_total", "Number of connections opened.")
+
+	tokenRefreshes = prometheus.NewDesc("todo_db_token_refreshes_total",
+		"Number of successful database token acquisitions.", nil, nil)
+	tokenFailures = prometheus.NewDesc("todo_db_token_refresh_failures_total",
+		"Number of failed database token acquisitions.", nil, nil)
+	tokenConsecutiveFailures = prometheus.NewDesc("todo_db_token_consecutive_failures",
+		"Number of token acquisitions that failed since the last success.", nil, nil)
+	tokenExpiresOn = prometheus.NewDesc("todo_db_token_expiry_timestamp_seconds",
+		"Expiry of the current database token as a Unix timestamp.", nil, nil)
+
+	replicaHealthy = prometheus.NewDesc("todo_db_replica_healthy",
+		"Whether the read replica is used for reads.", nil, nil)
+	replicaLag = prometheus.NewDesc("todo_db_replica_lag_seconds",
+		"Replication lag of the read replica at the last check.", nil, nil)
+)
+
+type collector struct {
+	ts *TodoStore
+}
+
+// Collector returns a Prometheus collector for the store's connection pools, database token
+// refreshes and read replica.
+func (ts *TodoStore) Collector() prometheus.Collector {
+	return collector{ts: ts}
+}
+
+func (c collector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c collector) Collect(ch chan<- prometheus.Metric) {
+	collectPool(ch, "primary", c.ts.pool.Stat())
+	if c.ts.replica != nil {
+		collectPool(ch, "replica", c.ts.replica.Stat())
+		status, _ := c.ts.ReplicaStatus()
+		healthy := 0.0
+		if status.Healthy {
+			healthy = 1
+		}
+		ch <- prometheus.MustNewConstMetric(replicaHealthy, prometheus.GaugeValue, healthy)
+		ch <- prometheus.MustNewConstMetric(replicaLag, prometheus.GaugeValue, status.Lag.Seconds())
+	}
+	if c.ts.tokens != nil {
+		stats := c.ts.TokenStats()
+		ch <- prometheus.MustNewConstMetric(tokenRefreshes, prometheus.CounterValue, float64(stats.Refreshes))
+		ch <- prometheus.MustNewConstMetric(tokenFailures, prometheus.CounterValue, float64(stats.Failures))
+		ch <- prometheus.MustNewConstMetric(tokenConsecutiveFailures, prometheus.GaugeValue, float64(stats.ConsecutiveFailures))
+		ch <- prometheus.MustNewConstMetric(tokenExpiresOn, prometheus.GaugeValue, unixSeconds(stats.ExpiresOn))
+	}
+}
+
+func collectPool(ch chan<- prometheus.Metric, pool string, s *pgxpool.Stat) {
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v, pool)
+	}
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v, pool)
+	}
+	gauge(poolAcquiredConns, float64(s.AcquiredConns()))
+	gauge(poolIdleConns, float64(s.IdleConns()))
+	gauge(poolTotalConns, float64(s.TotalConns()))
+	gauge(poolMaxConns, float64(s.MaxConns()))
+	counter(poolAcquires, float64(s.AcquireCount()))
+	counter(poolAcquireTime, s.AcquireDuration().Seconds())
+	counter(poolEmptyAcquires, float64(s.EmptyAcquireCount()))
+	counter(poolCanceled, float64(s.CanceledAcquireCount()))
+	counter(poolNewConns, float64(s.NewConnsCount()))
+}
+
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// PoolStats is a snapshot of a connection pool's statistics.
+type PoolStats struct {
+	AcquiredConns        int32         `json:"acquiredConns"`
+	IdleConns            int32         `json:"idleConns"`
+	ConstructingConns    int32         `json:"constructingConns"`
+	TotalConns           int32         `json:"totalConns"`
+	MaxConns             int32         `json:"maxConns"`
+	AcquireCount         int64         `json:"acquireCount"`
+	AcquireDuration      time.Duration `json:"acquireDuration"`
+	EmptyAcquireCount    int64         `json:"emptyAcquireCount"`
+	CanceledAcquireCount int64         `json:"canceledAcquireCount"`
+	NewConnsCount        int64         `json:"newConnsCount"`
+	MaxLifetimeDestroys  int64         `json:"maxLifetimeDestroyCount"`
+	MaxIdleDestroys      int64         `json:"maxIdleDestroyCount"`
+}
+
+// PoolStats returns statistics for the primary pool and, if configured, the replica pool.
+func (ts *TodoStore) PoolStats() map[string]PoolStats {
+	stats := map[string]PoolStats{"primary": poolStats(ts.pool.Stat())}
+	if ts.replica != nil {
+		stats["replica"] = poolStats(ts.replica.Stat())
+	}
+	return stats
+}
+
+func poolStats(s *pgxpool.Stat) PoolStats {
+	return PoolStats{
+		AcquiredConns:        s.AcquiredConns(),
+		IdleConns:            s.IdleConns(),
+		ConstructingConns:    s.ConstructingConns(),
+		TotalConns:           s.TotalConns(),
+		MaxConns:             s.MaxConns(),
+		AcquireCount:         s.AcquireCount(),
+		AcquireDuration:      s.AcquireDuration(),
+		EmptyAcquireCount:    s.EmptyAcquireCount(),
+		CanceledAcquireCount: s.CanceledAcquireCount(),
+		NewConnsCount:        s.NewConnsCount(),
+		MaxLifetimeDestroys:  s.MaxLifetimeDestroyCount(),
+		MaxIdleDestroys:      s.MaxIdleDestroyCount(),
+	}
+}
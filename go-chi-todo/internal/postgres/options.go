@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/envelope"
+)
+
+type options struct {
+	encryptor        *envelope.Encryptor
+	tokens           TokenProvider
+	replica          string
+	maxLag           time.Duration
+	statementTimeout time.Duration
+	slowQuery        time.Duration
+	minConns         int32
+	maxConns         int32
+}
+
+type Option func(*options)
+
+// WithEncryption encrypts todo details and stored idempotent responses with e. Rows written
+// without encryption remain readable and are encrypted by Reencrypt.
+func WithEncryption(e *envelope.Encryptor) Option {
+	return func(o *options) {
+		o.encryptor = e
+	}
+}
+
+// WithTokenProvider acquires access tokens from p if the connection string has no password.
+// It defaults to DefaultAzureCredential.
+func WithTokenProvider(p TokenProvider) Option {
+	return func(o *options) {
+		o.tokens = p
+	}
+}
+
+// WithReplica routes List and Find to the read replica at connString while it is healthy and
+// lags the primary by no more than maxLag.
+func WithReplica(connString string, maxLag time.Duration) Option {
+	return func(o *options) {
+		o.replica = connString
+		o.maxLag = maxLag
+	}
+}
+
+// WithStatementTimeout sets statement_timeout for all connections, so the server abandons
+// statements that outlive the requests waiting for them.
+func WithStatementTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.statementTimeout = d
+	}
+}
+
+// WithSlowQueryThreshold logs statements that take d or longer. Arguments are never logged.
+func WithSlowQueryThreshold(d time.Duration) Option {
+	return func(o *options) {
+		o.slowQuery = d
+	}
+}
+
+// WithPoolSize bounds the number of connections in each pool. Zero keeps the pgxpool default.
+func WithPoolSize(minConns, maxConns int32) Option {
+	return func(o *options) {
+		o.minConns = minConns
+		o.maxConns = maxConns
+	}
+}
@@ -0,0 +1,169 @@
+package postgres
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+const (
+	// maxStatements bounds the number of distinct statements tracked. The store only runs
+	// a fixed set of statements, so this is only reached if literals vary in the SQL text.
+	maxStatements = 200
+	// statSamples is the number of recent durations per statement used for percentiles.
+	statSamples     = 512
+	otherStatements = "(other)"
+)
+
+// QueryStat aggregates the executions of a statement since the store was created.
+type QueryStat struct {
+	Statement string        `json:"statement"`
+	Count     int64         `json:"count"`
+	Errors    int64         `json:"errors"`
+	Slow      int64         `json:"slow"`
+	P50       time.Duration `json:"p50"`
+	P95       time.Duration `json:"p95"`
+	Max       time.Duration `json:"max"`
+	Total     time.Duration `json:"total"`
+}
+
+type statementStats struct {
+	count, errors, slow int64
+	max, total          time.Duration
+	// samples is a ring buffer of the most recent durations.
+	samples []time.Duration
+	next    int
+}
+
+func (s *statementStats) observe(d time.Duration, err error, slow bool) {
+	s.count++
+	if err != nil {
+		s.errors++
+	}
+	if slow {
+		s.slow++
+	}
+	s.total += d
+	s.max = max(s.max, d)
+	if len(s.samples) < statSamples {
+		s.samples = append(s.samples, d)
+		return
+	}
+	s.samples[s.next] = d
+	s.next = (s.next + 1) % statSamples
+}
+
+// percentile returns the p-th percentile of sorted using the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := (p*len(sorted)+99)/100 - 1
+	return sorted[max(i, 0)]
+}
+
+type queryStartKey struct{}
+
+type queryStart struct {
+	at   time.Time
+	sql  string
+	args []any
+}
+
+// queryStats is a pgx.QueryTracer that measures every statement, logs statements slower than
+// threshold and keeps per-statement aggregates.
+type queryStats struct {
+	threshold time.Duration
+
+	mu    sync.Mutex
+	stmts map[string]*statementStats
+}
+
+var _ pgx.QueryTracer = (*queryStats)(nil)
+
+func newQueryStats(threshold time.Duration) *queryStats {
+	return &queryStats{threshold: threshold, stmts: make(map[string]*statementStats)}
+}
+
+func (q *queryStats) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{at: time.Now(), sql: data.SQL, args: data.Args})
+}
+
+func (q *queryStats) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	d := time.Since(start.at)
+	stmt := sanitize(start.sql)
+	slow := q.threshold > 0 && d >= q.threshold
+	q.observe(stmt, d, data.Err, slow)
+	if slow {
+		attrs := []any{slog.String("statement", stmt), slog.Duration("duration", d), slog.Any("args", argTypes(start.args))}
+		if data.Err != nil {
+			attrs = append(attrs, log.ErrorKey, data.Err)
+		}
+		slog.WarnContext(ctx, "slow query", attrs...)
+	}
+}
+
+func (q *queryStats) observe(stmt string, d time.Duration, err error, slow bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s, ok := q.stmts[stmt]
+	if !ok {
+		if len(q.stmts) >= maxStatements {
+			stmt = otherStatements
+			s = q.stmts[stmt]
+		}
+		if s == nil {
+			s = &statementStats{}
+			q.stmts[stmt] = s
+		}
+	}
+	s.observe(d, err, slow)
+}
+
+// snapshot returns the aggregates of all statements, ordered by total time spent.
+func (q *queryStats) snapshot() []QueryStat {
+	q.mu.Lock()
+	stats := make([]QueryStat, 0, len(q.stmts))
+	samples := make([][]time.Duration, 0, len(q.stmts))
+	for stmt, s := range q.stmts {
+		stats = append(stats, QueryStat{Statement: stmt, Count: s.count, Errors: s.errors, Slow: s.slow, Max: s.max, Total: s.total})
+		samples = append(samples, slices.Clone(s.samples))
+	}
+	q.mu.Unlock()
+
+	for i := range stats {
+		slices.Sort(samples[i])
+		stats[i].P50 = percentile(samples[i], 50)
+		stats[i].P95 = percentile(samples[i], 95)
+	}
+	slices.SortFunc(stats, func(a, b QueryStat) int {
+		return cmp.Compare(b.Total, a.Total)
+	})
+	return stats
+}
+
+// argTypes describes arguments by type only, so their values never reach the logs.
+func argTypes(args []any) []string {
+	types := make([]string, len(args))
+	for i, a := range args {
+		types[i] = fmt.Sprintf("%T", a)
+	}
+	return types
+}
+
+// QueryStats returns per-statement aggregates, ordered by total time spent.
+func (ts *TodoStore) QueryStats() []QueryStat {
+	return ts.queries.snapshot()
+}
@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    int
+		want time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{95, 95 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		if got := percentile(sorted, tc.p); got != tc.want {
+			t.Errorf("Want p%d %v, got %v", tc.p, tc.want, got)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("Want 0 for no samples, got %v", got)
+	}
+}
+
+func TestQueryStats(t *testing.T) {
+	q := newQueryStats(time.Second)
+	for i := range statSamples + 10 {
+		q.observe("SELECT 1", time.Duration(i+1)*time.Millisecond, nil, false)
+	}
+	q.observe("DELETE FROM todo WHERE id = $1", time.Millisecond, errors.New("test error"), false)
+
+	stats := q.snapshot()
+	if len(stats) != 2 {
+		t.Fatalf("Want 2 statements, got %d", len(stats))
+	}
+	got := stats[0]
+	if got.Statement != "SELECT 1" || got.Count != statSamples+10 {
+		t.Errorf("Want SELECT 1 with %d executions first, got %+v", statSamples+10, got)
+	}
+	// Only the most recent samples count towards percentiles, but max covers all executions.
+	if want := time.Duration(statSamples+10) * time.Millisecond; got.Max != want || got.P95 >= want || got.P50 <= 10*time.Millisecond {
+		t.Errorf("Want percentiles of recent samples and max %v, got %+v", want, got)
+	}
+	if stats[1].Errors != 1 {
+		t.Errorf("Want 1 error, got %d", stats[1].Errors)
+	}
+
+	for i := range maxStatements {
+		q.observe(fmt.Sprintf("SELECT %d", i), time.Millisecond, nil, false)
+	}
+	if n := len(q.snapshot()); n != maxStatements+1 {
+		t.Errorf("Want %d statements including %q, got %d", maxStatements+1, otherStatements, n)
+	}
+}
+
+func TestSlowQueryLog(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	q := newQueryStats(10 * time.Millisecond)
+	run := func(d time.Duration) {
+		ctx := q.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{
+			SQL:  "SELECT id FROM todo WHERE owner = $1 AND secret = 'literal'",
+			Args: []any{"alice@example.com"},
+		})
+		time.Sleep(d)
+		q.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	}
+
+	run(0)
+	if buf.Len() != 0 {
+		t.Errorf("Want no log for fast query, got %s", buf.String())
+	}
+	run(20 * time.Millisecond)
+	out := buf.String()
+	if !strings.Contains(out, "slow query") || !strings.Contains(out, `"args":["string"]`) {
+		t.Errorf("Want slow query log with argument types, got %s", out)
+	}
+	for _, secret := range []string{"alice@example.com", "literal"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("Want %q to be redacted, got %s", secret, out)
+		}
+	}
+	if got := q.snapshot()[0]; got.Count != 2 || got.Slow != 1 {
+		t.Errorf("Want 2 executions and 1 slow, got %+v", got)
+	}
+}
@@ -0,0 +1,136 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+const (
+	// refreshLead is how long before refreshSkew the background refresher renews the token,
+	// so connections never have to wait for a token.
+	refreshLead = 5 * time.Minute
+	// refreshJitter spreads refreshes of multiple replicas sharing an identity.
+	refreshJitter     = 30 * time.Second
+	minRefreshBackoff = time.Second
+	maxRefreshBackoff = time.Minute
+)
+
+// TokenStats describes the current access token and the outcome of past refreshes.
+type TokenStats struct {
+	AcquiredAt time.Time
+	ExpiresOn  time.Time
+	Refreshes  int64
+	Failures   int64
+	// ConsecutiveFailures is reset by the next successful refresh.
+	ConsecutiveFailures int
+}
+
+// TokenStats returns a snapshot of the access token state. It is zero when the connection
+// string includes a password.
+func (ts *TodoStore) TokenStats() TokenStats {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+	return ts.tokenStats
+}
+
+// fetchToken acquires a new token and records the outcome. The current token is kept on failure.
+func (ts *TodoStore) fetchToken(ctx context.Context) (azcore.AccessToken, error) {
+	at, err := ts.tokens.Token(ctx)
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if err != nil {
+		ts.tokenStats.Failures++
+		ts.tokenStats.ConsecutiveFailures++
+		return at, err
+	}
+	ts.accessToken = at
+	ts.tokenStats.AcquiredAt = time.Now().UTC()
+	ts.tokenStats.ExpiresOn = at.ExpiresOn
+	ts.tokenStats.Refreshes++
+	ts.tokenStats.ConsecutiveFailures = 0
+	return at, nil
+}
+
+// RefreshTokens renews the access token in the background ahead of its expiry until ctx is
+// cancelled. Failed refreshes are retried with jittered exponential backoff, while connections
+// continue to use the existing token. It returns immediately if the connection string includes
+// a password.
+func (ts *TodoStore) RefreshTokens(ctx context.Context) {
+	if ts.tokens == nil {
+		return
+	}
+	t := time.NewTimer(ts.nextRefresh(time.Now()))
+	defer t.Stop()
+	var stalled int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		prev := ts.TokenStats().ExpiresOn
+		ts.refreshMutex.Lock()
+		_, err := ts.fetchToken(ctx)
+		ts.refreshMutex.Unlock()
+		stats := ts.TokenStats()
+		if err != nil {
+			delay := refreshBackoff(stats.ConsecutiveFailures)
+			slog.Warn("refreshing access token",
+				slog.Int("failures", stats.ConsecutiveFailures),
+				slog.Duration("age", time.Since(stats.AcquiredAt).Round(time.Second)),
+				slog.Time("expiresOn", stats.ExpiresOn),
+				slog.Duration("retryIn", delay),
+				log.ErrorKey, err)
+			t.Reset(delay)
+			continue
+		}
+		// Providers may return a cached token that is about to expire. Renewing it again right
+		// away wouldn't help, so back off as if the refresh had failed.
+		if !stats.ExpiresOn.After(prev) {
+			stalled++
+			delay := refreshBackoff(stalled)
+			slog.Warn("access token was not renewed",
+				slog.Int("attempts", stalled),
+				slog.Time("expiresOn", stats.ExpiresOn),
+				slog.Duration("retryIn", delay))
+			t.Reset(delay)
+			continue
+		}
+		stalled = 0
+		slog.Info("refreshed access token",
+			slog.Time("expiresOn", stats.ExpiresOn),
+			slog.Duration("validFor", time.Until(stats.ExpiresOn).Round(time.Second)))
+		t.Reset(ts.nextRefresh(time.Now()))
+	}
+}
+
+// nextRefresh returns how long to wait until the token should be renewed. Without a token,
+// it is renewed right away. Otherwise it waits at least minRefreshBackoff, so tokens that are
+// short-lived or already stale aren't renewed in a busy loop.
+func (ts *TodoStore) nextRefresh(now time.Time) time.Duration {
+	ts.mutex.RLock()
+	expiresOn := ts.accessToken.ExpiresOn
+	ts.mutex.RUnlock()
+	if expiresOn.IsZero() {
+		return 0
+	}
+	d := expiresOn.Sub(now) - refreshSkew - refreshLead - rand.N(refreshJitter)
+	return max(d, minRefreshBackoff)
+}
+
+// refreshBackoff returns a jittered delay for the given number of consecutive failures.
+func refreshBackoff(failures int) time.Duration {
+	d := maxRefreshBackoff
+	if failures < 10 {
+		d = min(minRefreshBackoff<<failures, maxRefreshBackoff)
+	}
+	// Equal jitter keeps the delay within [d/2, d).
+	return d/2 + rand.N(d/2)
+}
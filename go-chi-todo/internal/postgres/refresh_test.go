@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/jackc/pgx/v5"
+)
+
+type failingProvider struct{}
+
+func (failingProvider) Token(context.Context) (azcore.AccessToken, error) {
+	return azcore.AccessToken{}, errors.New("identity endpoint unavailable")
+}
+
+// cachedProvider returns the same token every time, like a provider with a stale cache.
+type cachedProvider struct {
+	token azcore.AccessToken
+	calls atomic.Int64
+}
+
+func (p *cachedProvider) Token(context.Context) (azcore.AccessToken, error) {
+	p.calls.Add(1)
+	return p.token, nil
+}
+
+func TestRefreshBackoff(t *testing.T) {
+	for failures := 1; failures < 20; failures++ {
+		want := min(minRefreshBackoff<<min(failures, 10), maxRefreshBackoff)
+		for range 10 {
+			if got := refreshBackoff(failures); got < want/2 || got >= want {
+				t.Fatalf("Want backoff for %d failures in [%v, %v), got %v", failures, want/2, want, got)
+			}
+		}
+	}
+}
+
+func TestNextRefresh(t *testing.T) {
+	now := time.Now()
+	var ts TodoStore
+	if got := ts.nextRefresh(now); got != 0 {
+		t.Errorf("Want immediate refresh without token, got %v", got)
+	}
+
+	ts.accessToken.ExpiresOn = now.Add(time.Hour)
+	latest := time.Hour - refreshSkew - refreshLead
+	if got := ts.nextRefresh(now); got > latest || got <= latest-refreshJitter {
+		t.Errorf("Want refresh in (%v, %v], got %v", latest-refreshJitter, latest, got)
+	}
+
+	ts.accessToken.ExpiresOn = now.Add(refreshSkew)
+	if got := ts.nextRefresh(now); got != minRefreshBackoff {
+		t.Errorf("Want refresh of stale token in %v, got %v", minRefreshBackoff, got)
+	}
+
+	ts.accessToken.ExpiresOn = now.Add(fileTokenValidity)
+	if got := ts.nextRefresh(now); got <= minRefreshBackoff {
+		t.Errorf("Want refresh of file token after more than %v, got %v", minRefreshBackoff, got)
+	}
+}
+
+func TestRefreshTokensWithoutProgress(t *testing.T) {
+	p := &cachedProvider{token: azcore.AccessToken{Token: "cached", ExpiresOn: time.Now().Add(refreshSkew)}}
+	ts := TodoStore{tokens: p}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	ts.RefreshTokens(ctx)
+	if got := p.calls.Load(); got != 1 {
+		t.Errorf("Want 1 token request, got %d", got)
+	}
+}
+
+func TestRefreshFailureKeepsToken(t *testing.T) {
+	expiresOn := time.Now().Add(time.Minute)
+	ts := TodoStore{tokens: failingProvider{}}
+	ts.accessToken = azcore.AccessToken{Token: "stale", ExpiresOn: expiresOn}
+
+	if _, err := ts.fetchToken(context.Background()); err == nil {
+		t.Fatal("Want refresh error")
+	}
+	stats := ts.TokenStats()
+	if stats.Failures != 1 || stats.ConsecutiveFailures != 1 {
+		t.Errorf("Want 1 failure, got %d (%d consecutive)", stats.Failures, stats.ConsecutiveFailures)
+	}
+
+	// The stale token is still used for new connections until it expires.
+	var cfg pgx.ConnConfig
+	if err := ts.beforeConnect(context.Background(), &cfg); err != nil {
+		t.Fatalf("Fatal error in beforeConnect: %v", err)
+	}
+	if cfg.Password != "stale" {
+		t.Errorf("Want password %q, got %q", "stale", cfg.Password)
+	}
+	if ok, _ := ts.prepareConn(context.Background(), nil); !ok {
+		t.Error("Want connections to be kept while the token is valid")
+	}
+
+	ts.accessToken.ExpiresOn = time.Now().Add(-time.Second)
+	cfg = pgx.ConnConfig{}
+	if err := ts.beforeConnect(context.Background(), &cfg); err == nil {
+		t.Error("Want error once the token expired")
+	}
+	if ok, _ := ts.prepareConn(context.Background(), nil); ok {
+		t.Error("Want connections to be rejected once the token expired")
+	}
+}
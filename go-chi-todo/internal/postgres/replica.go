@@ -0,0 +1,115 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+const replicaCheckTimeout = 5 * time.Second
+
+// replicaLagQuery returns whether the replica receives WAL from the primary and how far it is
+// behind in seconds. A replica that has replayed everything it received is considered current,
+// even if the primary has been idle, but only while it is streaming. Otherwise it may be missing
+// changes it doesn't know about. Reading the WAL receiver status requires pg_read_all_stats.
+const replicaLagQuery = `SELECT
+	NOT pg_is_in_recovery() OR EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming'),
+	CASE
+	WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+	END::float8`
+
+// errNotStreaming means the replica's WAL receiver isn't connected to the primary.
+var errNotStreaming = errors.New("replica is not streaming from the primary")
+
+type ReplicaStatus struct {
+	Healthy   bool
+	Lag       time.Duration
+	CheckedAt time.Time
+}
+
+// ReplicaStatus returns the result of the last replica health check. ok is false if no
+// replica is configured.
+func (ts *TodoStore) ReplicaStatus() (status ReplicaStatus, ok bool) {
+	if ts.replica == nil {
+		return ReplicaStatus{}, false
+	}
+	return *ts.replicaStatus.Load(), true
+}
+
+// reader returns the pool to run a read-only query on. Reads go to the replica unless it is
+// unhealthy or lagging, or the caller requires read-your-writes consistency.
+func (ts *TodoStore) reader(ctx context.Context) *pgxpool.Pool {
+	if ts.replica == nil || model.ReadYourWritesFromContext(ctx) {
+		return ts.pool
+	}
+	if s := ts.replicaStatus.Load(); !s.Healthy || s.Lag > ts.replicaMaxLag {
+		return ts.pool
+	}
+	return ts.replica
+}
+
+// observeRead takes the replica out of rotation as soon as a query fails with a connection
+// error, instead of waiting for the next health check. Retries then go to the primary.
+func (ts *TodoStore) observeRead(ctx context.Context, pool *pgxpool.Pool, err error) {
+	if pool != ts.replica || !isRetryable(err) {
+		return
+	}
+	if s := ts.replicaStatus.Load(); s.Healthy {
+		slog.WarnContext(ctx, "read replica failed, falling back to primary", log.ErrorKey, err)
+		ts.replicaStatus.Store(&ReplicaStatus{Lag: s.Lag, CheckedAt: time.Now().UTC()})
+	}
+}
+
+func (ts *TodoStore) checkReplica(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, replicaCheckTimeout)
+	defer cancel()
+
+	prev := ts.replicaStatus.Load()
+	status := ReplicaStatus{CheckedAt: time.Now().UTC()}
+	var streaming bool
+	var lag float64
+	err := ts.replica.QueryRow(ctx, replicaLagQuery).Scan(&streaming, &lag)
+	if err == nil && !streaming {
+		err = errNotStreaming
+	}
+	if err != nil {
+		if prev == nil || prev.Healthy {
+			slog.Warn("read replica is unhealthy", log.ErrorKey, err)
+		}
+		ts.replicaStatus.Store(&status)
+		return
+	}
+	status.Healthy = true
+	status.Lag = time.Duration(lag * float64(time.Second))
+	ts.replicaStatus.Store(&status)
+
+	usable := status.Lag <= ts.replicaMaxLag
+	if prev == nil || prev.Healthy != status.Healthy || (prev.Lag <= ts.replicaMaxLag) != usable {
+		slog.Info("read replica status", slog.Bool("usable", usable), slog.Duration("lag", status.Lag), slog.Duration("maxLag", ts.replicaMaxLag))
+	}
+}
+
+// MonitorReplica checks the replica's health and lag every interval until ctx is cancelled.
+// It returns immediately if no replica is configured.
+func (ts *TodoStore) MonitorReplica(ctx context.Context, interval time.Duration) {
+	if ts.replica == nil {
+		return
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			ts.checkReplica(ctx)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+func TestReader(t *testing.T) {
+	primary, replica := &pgxpool.Pool{}, &pgxpool.Pool{}
+	tests := []struct {
+		name           string
+		status         ReplicaStatus
+		readYourWrites bool
+		want           *pgxpool.Pool
+	}{
+		{name: "healthy", status: ReplicaStatus{Healthy: true, Lag: time.Second}, want: replica},
+		{name: "unhealthy", status: ReplicaStatus{}, want: primary},
+		{name: "lagging", status: ReplicaStatus{Healthy: true, Lag: time.Minute}, want: primary},
+		{name: "read_your_writes", status: ReplicaStatus{Healthy: true}, readYourWrites: true, want: primary},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := TodoStore{pool: primary, replica: replica, replicaMaxLag: 30 * time.Second}
+			ts.replicaStatus.Store(&tc.status)
+			ctx := context.Background()
+			if tc.readYourWrites {
+				ctx = model.NewReadYourWritesContext(ctx)
+			}
+			if got := ts.reader(ctx); got != tc.want {
+				t.Errorf("Want primary %v, got primary %v", tc.want == primary, got == primary)
+			}
+		})
+	}
+
+	ts := TodoStore{pool: primary}
+	if got := ts.reader(context.Background()); got != primary {
+		t.Error("Want primary without replica")
+	}
+}
+
+func TestObserveRead(t *testing.T) {
+	primary, replica := &pgxpool.Pool{}, &pgxpool.Pool{}
+	ts := TodoStore{pool: primary, replica: replica, replicaMaxLag: time.Minute}
+	ts.replicaStatus.Store(&ReplicaStatus{Healthy: true})
+
+	ts.observeRead(context.Background(), replica, errors.New("no rows"))
+	ts.observeRead(context.Background(), primary, &pgconn.PgError{Code: "57P01"})
+	if ts.reader(context.Background()) != replica {
+		t.Fatal("Want replica after unrelated errors")
+	}
+	ts.observeRead(context.Background(), replica, &pgconn.PgError{Code: "57P01"})
+	if ts.reader(context.Background()) != primary {
+		t.Error("Want primary after replica connection error")
+	}
+}
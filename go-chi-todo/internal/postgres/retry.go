@@ -0,0 +1,114 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// retryPolicy retries transient errors such as those caused by a failover with capped
+// exponential backoff.
+type retryPolicy struct {
+	attempts   int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+var defaultRetryPolicy = retryPolicy{attempts: 4, minBackoff: 50 * time.Millisecond, maxBackoff: time.Second}
+
+// retryableCodes are SQLSTATEs after which the statement can be run again. Class 08
+// (connection exceptions) is checked separately.
+var retryableCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// isRetryable reports whether err is transient.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return retryableCodes[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08")
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// do runs fn until it succeeds, fails permanently, or the attempts or ctx deadline are
+// exhausted. Operations that are not idempotent are only retried if the server cannot have
+// received the statement.
+func (p retryPolicy) do(ctx context.Context, idempotent bool, fn func(context.Context) error) error {
+	var err error
+	for attempt := range p.attempts {
+		if err = fn(ctx); err == nil {
+			return nil
+		}
+		if !pgconn.SafeToRetry(err) && (!idempotent || !isRetryable(err)) {
+			return classify(ctx, err)
+		}
+		if attempt == p.attempts-1 {
+			break
+		}
+		d := min(p.minBackoff<<attempt, p.maxBackoff)
+		d = d/2 + rand.N(d/2+1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			return err
+		}
+		slog.WarnContext(ctx, "retrying database operation", slog.Int("attempt", attempt+1), slog.Duration("backoff", d), log.ErrorKey, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(d):
+		}
+	}
+	return err
+}
+
+// remove runs a deletion with do. If a retry finds nothing to delete, the failed attempt may
+// have deleted it before its connection broke, so that counts as success.
+func (p retryPolicy) remove(ctx context.Context, fn func(context.Context) error) error {
+	var retried bool
+	return p.do(ctx, true, func(ctx context.Context) error {
+		err := fn(ctx)
+		if retried && errors.Is(err, model.ErrEmptyResultSet) {
+			return nil
+		}
+		retried = true
+		return err
+	})
+}
+
+// classify marks statements cancelled by statement_timeout as model.ErrTimeout. Cancellations
+// caused by ctx already report the context's error.
+func classify(ctx context.Context, err error) error {
+	var pgErr *pgconn.PgError
+	if ctx.Err() == nil && errors.As(err, &pgErr) && pgErr.Code == "57014" {
+		return fmt.Errorf("%w: %w", model.ErrTimeout, err)
+	}
+	return err
+}
@@ -0,0 +1,148 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// unsentError is an error returned before the statement was sent to the server.
+type unsentError struct{}
+
+func (unsentError) Error() string     { return "connection closed before sending" }
+func (unsentError) SafeToRetry() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "admin_shutdown", err: &pgconn.PgError{Code: "57P01"}, want: true},
+		{name: "connection_failure", err: &pgconn.PgError{Code: "08006"}, want: true},
+		{name: "serialization_failure", err: fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "40001"}), want: true},
+		{name: "unique_violation", err: &pgconn.PgError{Code: "23505"}, want: false},
+		{name: "syntax_error", err: &pgconn.PgError{Code: "42601"}, want: false},
+		{name: "connection_reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+		{name: "unexpected_eof", err: io.ErrUnexpectedEOF, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "deadline", err: context.DeadlineExceeded, want: false},
+		{name: "other", err: errors.New("boom"), want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isRetryable(tc.err); got != tc.want {
+				t.Errorf("Want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := retryPolicy{attempts: 3, minBackoff: time.Millisecond, maxBackoff: 2 * time.Millisecond}
+	shutdown := &pgconn.PgError{Code: "57P01"}
+	tests := []struct {
+		name       string
+		idempotent bool
+		errs       []error
+		wantCalls  int
+		wantErr    bool
+	}{
+		{name: "success", idempotent: true, errs: []error{nil}, wantCalls: 1},
+		{name: "recovers", idempotent: true, errs: []error{shutdown, shutdown, nil}, wantCalls: 3},
+		{name: "exhausted", idempotent: true, errs: []error{shutdown, shutdown, shutdown}, wantCalls: 3, wantErr: true},
+		{name: "permanent", idempotent: true, errs: []error{&pgconn.PgError{Code: "23505"}}, wantCalls: 1, wantErr: true},
+		{name: "not_idempotent", idempotent: false, errs: []error{shutdown}, wantCalls: 1, wantErr: true},
+		{name: "not_idempotent_unsent", idempotent: false, errs: []error{unsentError{}, nil}, wantCalls: 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			err := p.do(context.Background(), tc.idempotent, func(context.Context) error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+			if calls != tc.wantCalls {
+				t.Errorf("Want %d calls, got %d", tc.wantCalls, calls)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Want error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRetryRemove(t *testing.T) {
+	p := retryPolicy{attempts: 3, minBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+	shutdown := &pgconn.PgError{Code: "57P01"}
+	tests := []struct {
+		name    string
+		errs    []error
+		wantErr error
+	}{
+		{name: "deleted", errs: []error{nil}},
+		{name: "missing", errs: []error{model.ErrEmptyResultSet}, wantErr: model.ErrEmptyResultSet},
+		{name: "missing_after_retry", errs: []error{shutdown, model.ErrEmptyResultSet}},
+		{name: "forbidden_after_retry", errs: []error{shutdown, model.ErrForbidden}, wantErr: model.ErrForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int
+			err := p.remove(context.Background(), func(context.Context) error {
+				err := tc.errs[calls]
+				calls++
+				return err
+			})
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Want error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRetryWithinDeadline(t *testing.T) {
+	p := retryPolicy{attempts: 5, minBackoff: time.Second, maxBackoff: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var calls int
+	start := time.Now()
+	err := p.do(ctx, true, func(context.Context) error {
+		calls++
+		return &pgconn.PgError{Code: "57P01"}
+	})
+	if err == nil {
+		t.Fatal("Want error")
+	}
+	if calls != 1 {
+		t.Errorf("Want 1 call, got %d", calls)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("Want to give up without waiting past the deadline, took %v", d)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	canceled := &pgconn.PgError{Code: "57014"}
+	if err := classify(context.Background(), canceled); !errors.Is(err, model.ErrTimeout) {
+		t.Errorf("Want ErrTimeout for statement timeout, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := classify(ctx, canceled); errors.Is(err, model.ErrTimeout) {
+		t.Errorf("Want cancellation by the caller not to be a timeout, got %v", err)
+	}
+	if err := classify(context.Background(), &pgconn.PgError{Code: "23505"}); errors.Is(err, model.ErrTimeout) {
+		t.Errorf("Want other errors unchanged, got %v", err)
+	}
+}
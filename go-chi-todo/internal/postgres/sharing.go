@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// Access rules for todos. Public todos are visible to and editable by everyone. Other todos
+// are visible to their owner, anyone they have been shared with and anyone their list has been
+// shared with. Only editors and owners may change them and only owners may delete them.
+// Queries using these predicates must alias todo as t and pass the caller's principal ID as
+// $1, which is NULL for anonymous callers, so they only match public todos.
+const (
+	canView = `(t.public OR t.owner = $1
+		OR EXISTS (SELECT 1 FROM todo_acl a WHERE a.todo_id = t.id AND a.principal = $1)
+		OR EXISTS (SELECT 1 FROM todo_list l WHERE l.id = t.list_id AND ` + canViewList + `))`
+	canEdit = `(t.public OR t.owner = $1
+		OR EXISTS (SELECT 1 FROM todo_acl a WHERE a.todo_id = t.id AND a.principal = $1 AND a.role = 'editor')
+		OR EXISTS (SELECT 1 FROM todo_list l WHERE l.id = t.list_id AND ` + canEditList + `))`
+	canDelete = `(t.public OR t.owner = $1 OR EXISTS (SELECT 1 FROM todo_list l WHERE l.id = t.list_id AND l.owner = $1))`
+)
+
+var _ model.CollaboratorStore = (*TodoStore)(nil)
+
+// caller returns the verified principal of the request, which the router only sets for
+// trusted authentication headers.
+func caller(ctx context.Context) *string {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return &p.ID
+}
+
+// access reports whether the caller can see the todo and whether the caller owns it.
+func (ts *TodoStore) access(ctx context.Context, id int) (visible bool, owner bool, err error) {
+	err = ts.pool.QueryRow(
+		ctx,
+		`SELECT COALESCE(t.owner = $1, false) FROM todo t WHERE t.id = $2 AND `+canView,
+		caller(ctx),
+		int64(id)).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	return true, owner, nil
+}
+
+// deniedOrMissing explains why a statement guarded by an access predicate affected no rows.
+// Todos the caller cannot see are reported as missing so their existence is not disclosed.
+func (ts *TodoStore) deniedOrMissing(ctx context.Context, id int) error {
+	visible, _, err := ts.access(ctx, id)
+	if err != nil {
+		return err
+	}
+	if visible {
+		return model.ErrForbidden
+	}
+	return model.ErrEmptyResultSet
+}
+
+func (ts *TodoStore) Collaborators(ctx context.Context, todoID int) ([]model.Collaborator, error) {
+	var collaborators []model.Collaborator
+	err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+		var err error
+		collaborators, err = ts.collaborators(ctx, todoID)
+		return err
+	})
+	return collaborators, err
+}
+
+func (ts *TodoStore) collaborators(ctx context.Context, todoID int) ([]model.Collaborator, error) {
+	rows, err := ts.pool.Query(
+		ctx,
+		`SELECT t.owner, a.principal, a.role FROM todo t LEFT JOIN todo_acl a ON a.todo_id = t.id
+		WHERE t.id = $2 AND `+canView+` ORDER BY a.principal`,
+		caller(ctx),
+		int64(todoID))
+	if err != nil {
+		return nil, err
+	}
+	return collectCollaborators(rows)
+}
+
+// collectCollaborators reads rows of owner, principal and role, where principal and role are
+// NULL if nothing has been shared. The owner is listed first, if there is one.
+func collectCollaborators(rows pgx.Rows) ([]model.Collaborator, error) {
+	defer rows.Close()
+	var found bool
+	collaborators := []model.Collaborator{}
+	for rows.Next() {
+		var owner, principal, role *string
+		if err := rows.Scan(&owner, &principal, &role); err != nil {
+			return nil, err
+		}
+		if !found && owner != nil {
+			collaborators = append(collaborators, model.Collaborator{Principal: *owner, Role: model.RoleOwner})
+		}
+		found = true
+		if principal != nil {
+			collaborators = append(collaborators, model.Collaborator{Principal: *principal, Role: model.Role(*role)})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, model.ErrEmptyResultSet
+	}
+	return collaborators, nil
+}
+
+func (ts *TodoStore) Grant(ctx context.Context, todoID int, c model.Collaborator) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	return ts.retry.do(ctx, true, func(ctx context.Context) error {
+		return ts.grant(ctx, todoID, c)
+	})
+}
+
+func (ts *TodoStore) grant(ctx context.Context, todoID int, c model.Collaborator) error {
+	tag, err := ts.pool.Exec(
+		ctx,
+		`INSERT INTO todo_acl (todo_id, principal, role) SELECT t.id, $3, $4 FROM todo t WHERE t.id = $2 AND t.owner = $1
+		ON CONFLICT (todo_id, principal) DO UPDATE SET role = EXCLUDED.role, granted_at = now()`,
+		caller(ctx),
+		int64(todoID),
+		c.Principal,
+		string(c.Role))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ts.deniedOrMissing(ctx, todoID)
+	}
+	return nil
+}
+
+// Revoke removes a collaborator. Owners can revoke anyone, collaborators can only remove themselves.
+func (ts *TodoStore) Revoke(ctx context.Context, todoID int, principal string) error {
+	return ts.retry.remove(ctx, func(ctx context.Context) error {
+		return ts.revoke(ctx, todoID, principal)
+	})
+}
+
+func (ts *TodoStore) revoke(ctx context.Context, todoID int, principal string) error {
+	tag, err := ts.pool.Exec(
+		ctx,
+		`DELETE FROM todo_acl a USING todo t WHERE a.todo_id = t.id AND t.id = $2 AND a.principal = $3 AND (t.owner = $1 OR a.principal = $1)`,
+		caller(ctx),
+		int64(todoID),
+		principal)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+	visible, owner, err := ts.access(ctx, todoID)
+	if err != nil {
+		return err
+	}
+	if !visible || owner {
+		return model.ErrEmptyResultSet
+	}
+	if p := caller(ctx); p != nil && *p == principal {
+		return model.ErrEmptyResultSet
+	}
+	return model.ErrForbidden
+}
@@ -3,39 +3,62 @@ package postgres
 import (
 	"context"
 	"errors"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"log/slog"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/envelope"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
 )
 
-const ossRDBMS = "https://ossrdbms-aad.database.windows.net/.default"
-
-var defaultOpts = policy.TokenRequestOptions{Scopes: []string{ossRDBMS}}
-
 type TodoStore struct {
-	pool         *pgxpool.Pool
-	accessToken  azcore.AccessToken
-	mutex        sync.RWMutex
-	refreshMutex sync.Mutex
+	pool          *pgxpool.Pool
+	replica       *pgxpool.Pool
+	replicaMaxLag time.Duration
+	replicaStatus atomic.Pointer[ReplicaStatus]
+	tokens        TokenProvider
+	accessToken   azcore.AccessToken
+	tokenStats    TokenStats
+	mutex         sync.RWMutex
+	refreshMutex  sync.Mutex
+	enc           *envelope.Encryptor
+	retry         retryPolicy
+	// statementTimeout is applied by the server to each statement, if set.
+	statementTimeout time.Duration
+	queries          *queryStats
+	minConns         int32
+	maxConns         int32
 }
 
-func NewStore(ctx context.Context, connString string) (*TodoStore, error) {
-	var store TodoStore
-	config, err := pgxpool.ParseConfig(connString)
+func NewStore(ctx context.Context, connString string, opts ...Option) (*TodoStore, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	store := TodoStore{enc: o.encryptor, tokens: o.tokens, retry: defaultRetryPolicy, replicaMaxLag: o.maxLag, statementTimeout: o.statementTimeout, queries: newQueryStats(o.slowQuery), minConns: o.minConns, maxConns: o.maxConns}
+	config, err := store.poolConfig(connString)
 	if err != nil {
 		return nil, err
 	}
-	config.PrepareConn = store.prepareConn
-	config.BeforeConnect = store.beforeConnect
+	var replicaConfig *pgxpool.Config
+	if o.replica != "" {
+		if replicaConfig, err = store.poolConfig(o.replica); err != nil {
+			return nil, err
+		}
+	}
+	passwordless := config.ConnConfig.Password == "" || (replicaConfig != nil && replicaConfig.ConnConfig.Password == "")
+	if store.tokens, err = tokenProvider(ctx, store.tokens, passwordless); err != nil {
+		return nil, err
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
@@ -45,19 +68,73 @@ func NewStore(ctx context.Context, connString string) (*TodoStore, error) {
 	if err := pool.Ping(ctx); err != nil {
 		return nil, err
 	}
-
 	store.pool = pool
+
+	if replicaConfig != nil {
+		// The replica is optional, so it may still be unavailable when the store starts.
+		if store.replica, err = pgxpool.NewWithConfig(ctx, replicaConfig); err != nil {
+			pool.Close()
+			return nil, err
+		}
+		store.checkReplica(ctx)
+	}
 	return &store, nil
 }
 
+// tokenProvider returns the provider for connections without a password, which defaults to
+// the Azure default credential. It is nil if every connection string includes a password, so
+// tokens are neither acquired nor refreshed.
+func tokenProvider(ctx context.Context, configured TokenProvider, passwordless bool) (TokenProvider, error) {
+	switch {
+	case !passwordless:
+		if configured != nil {
+			slog.WarnContext(ctx, "ignoring token provider, the connection string includes a password")
+		}
+		return nil, nil
+	case configured != nil:
+		return configured, nil
+	}
+	return NewTokenProvider(TokenConfig{})
+}
+
+func (ts *TodoStore) poolConfig(connString string) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	config.PrepareConn = ts.prepareConn
+	config.BeforeConnect = ts.beforeConnect
+	config.ConnConfig.Tracer = multitracer.New(queryTracer{}, ts.queries)
+	if ts.statementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(ts.statementTimeout.Milliseconds(), 10)
+	}
+	if ts.maxConns > 0 {
+		config.MaxConns = ts.maxConns
+	}
+	if ts.minConns > 0 {
+		config.MinConns = min(ts.minConns, config.MaxConns)
+	}
+	return config, nil
+}
+
+// refreshSkew is how long before expiry a token is considered stale and renewed on demand,
+// to avoid clock skew / mid-request failures.
+const refreshSkew = 2 * time.Minute
+
+// getAndCheckToken returns the current token and whether it is fresh, i.e. outside refreshSkew.
 func (ts *TodoStore) getAndCheckToken() (string, bool) {
 	ts.mutex.RLock()
 	defer ts.mutex.RUnlock()
-	// Refresh slightly before actual expiry to avoid clock skew / mid-request failures.
-	refreshSkew := 2 * time.Minute
 	return ts.accessToken.Token, ts.accessToken.ExpiresOn.After(time.Now().UTC().Add(refreshSkew))
 }
 
+// usableToken returns the current token if it has not expired yet, even if it is stale.
+func (ts *TodoStore) usableToken() (string, bool) {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+	return ts.accessToken.Token, ts.accessToken.Token != "" && ts.accessToken.ExpiresOn.After(time.Now().UTC())
+}
+
 func (ts *TodoStore) acquireToken(ctx context.Context) (string, error) {
 	// Ensure only one goroutine refreshes the token at a time, without holding
 	// the RWMutex during the network call.
@@ -69,75 +146,84 @@ func (ts *TodoStore) acquireToken(ctx context.Context) (string, error) {
 		return token, nil
 	}
 
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	if err != nil {
-		return "", err
-	}
-
-	at, err := cred.GetToken(ctx, defaultOpts)
+	at, err := ts.fetchToken(ctx)
 	if err != nil {
 		return "", err
 	}
-
-	ts.mutex.Lock()
-	ts.accessToken = at
-	ts.mutex.Unlock()
 	return at.Token, nil
 }
 
 func (ts *TodoStore) prepareConn(ctx context.Context, conn *pgx.Conn) (bool, error) {
-	slog.Debug("BeforeAcquire: checking access token")
-	token, ok := ts.getAndCheckToken()
+	slog.DebugContext(ctx, "BeforeAcquire: checking access token")
+	token, ok := ts.usableToken()
 	if token == "" {
-		slog.Debug("BeforeAcquire: no access token set")
+		slog.DebugContext(ctx, "BeforeAcquire: no access token set")
 		return true, nil
 	}
-	slog.Debug("BeforeAcquire: access token validity", slog.Bool("valid", ok))
+	slog.DebugContext(ctx, "BeforeAcquire: access token validity", slog.Bool("valid", ok))
 	return ok, nil
 }
 
 func (ts *TodoStore) beforeConnect(ctx context.Context, config *pgx.ConnConfig) error {
 	if config.Password != "" {
-		slog.Debug("BeforeConnect: password is set")
+		slog.DebugContext(ctx, "BeforeConnect: password is set")
 		return nil
 	}
-	slog.Debug("BeforeConnect: no password set, checking access token")
+	slog.DebugContext(ctx, "BeforeConnect: no password set, checking access token")
 	token, ok := ts.getAndCheckToken()
 	if !ok {
-		slog.Debug("BeforeConnect: acquiring access token")
-		var err error
-		if token, err = ts.acquireToken(ctx); err != nil {
-			return err
+		slog.DebugContext(ctx, "BeforeConnect: acquiring access token")
+		acquired, err := ts.acquireToken(ctx)
+		if err != nil {
+			// Keep connecting with the stale token until it actually expires.
+			var usable bool
+			if token, usable = ts.usableToken(); !usable {
+				return err
+			}
+			slog.WarnContext(ctx, "BeforeConnect: acquiring access token failed, using existing token", log.ErrorKey, err)
+		} else {
+			token = acquired
 		}
 	}
 	config.Password = token
-	slog.Debug("BeforeConnect: configured password from access token")
+	slog.DebugContext(ctx, "BeforeConnect: configured password from access token")
 	return nil
 }
 
 func (ts *TodoStore) List(ctx context.Context, offset int, limit int) ([]model.Todo, error) {
-	rows, err := ts.pool.Query(
-		ctx,
-		`SELECT id, description, details, done FROM todo ORDER BY description OFFSET $1 LIMIT $2`,
-		int64(offset),
-		int64(limit))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	return pgx.CollectRows(rows, pgx.RowToStructByPos[model.Todo])
+	var items []model.Todo
+	err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+		pool := ts.reader(ctx)
+		rows, err := pool.Query(
+			ctx,
+			`SELECT `+todoColumns+` FROM todo t WHERE `+canView+` ORDER BY t.description OFFSET $2 LIMIT $3`,
+			caller(ctx),
+			int64(offset),
+			int64(limit))
+		if err == nil {
+			items, err = pgx.CollectRows(rows, ts.scanTodo(ctx))
+		}
+		ts.observeRead(ctx, pool, err)
+		return err
+	})
+	return items, err
 }
 
 func (ts *TodoStore) Find(ctx context.Context, id int) (model.Todo, error) {
-	rows, err := ts.pool.Query(
-		ctx,
-		`SELECT id, description, details, done FROM todo WHERE id = $1`,
-		int64(id))
-	if err != nil {
-		return model.Todo{}, err
-	}
-	defer rows.Close()
-	item, err := pgx.CollectOneRow(rows, pgx.RowToStructByPos[model.Todo])
+	var item model.Todo
+	err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+		pool := ts.reader(ctx)
+		rows, err := pool.Query(
+			ctx,
+			`SELECT `+todoColumns+` FROM todo t WHERE t.id = $2 AND `+canView,
+			caller(ctx),
+			int64(id))
+		if err == nil {
+			item, err = pgx.CollectOneRow(rows, ts.scanTodo(ctx))
+		}
+		ts.observeRead(ctx, pool, err)
+		return err
+	})
 	if err != nil {
 		if !errors.Is(err, pgx.ErrNoRows) {
 			return model.Todo{}, err
@@ -149,49 +235,97 @@ func (ts *TodoStore) Find(ctx context.Context, id int) (model.Todo, error) {
 }
 
 func (ts *TodoStore) Create(ctx context.Context, item model.Todo) (model.Todo, error) {
-	var id int64
-	// We're using QueryRow() instead of Exec() since this allows us to capture the value of the RETURNING clause
-	row := ts.pool.QueryRow(
-		ctx,
-		`INSERT INTO todo (id, description, details, done) VALUES (DEFAULT, $1, $2, $3) RETURNING id`,
-		item.Description, item.Details, item.Done)
-	err := row.Scan(&id)
+	item.Normalize()
+	if err := item.Validate(); err != nil {
+		return item, err
+	}
+	// Encrypted details are bound to the id of their row, so the id is allocated before
+	// sealing them.
+	var id *int64
+	var aad []byte
+	if ts.enc != nil {
+		err := ts.retry.do(ctx, true, func(ctx context.Context) error {
+			return ts.pool.QueryRow(ctx, `SELECT nextval(pg_get_serial_sequence('todo', 'id'))`).Scan(&id)
+		})
+		if err != nil {
+			return item, err
+		}
+		aad = detailsAAD(*id)
+	}
+	details, err := ts.seal(ctx, item.Details, aad)
 	if err != nil {
 		return item, err
 	}
-	item.Id = id
-	return item, nil
+	// Inserting is only idempotent if the request has an idempotency key. A retried insert then
+	// conflicts with the row inserted by the first attempt and returns its id.
+	var requestKey *string
+	if key, ok := model.IdempotencyKeyFromContext(ctx); ok {
+		requestKey = &key
+	}
+	err = ts.retry.do(ctx, requestKey != nil, func(ctx context.Context) error {
+		// Todos can only be added to lists the caller can edit. Anonymous callers create
+		// public todos, the router only lets them if anonymous access is enabled.
+		err := ts.pool.QueryRow(
+			ctx,
+			`INSERT INTO todo (id, description, details, details_ciphertext, details_key, details_key_version, done, owner, public, request_key, list_id)
+			SELECT COALESCE($10::bigint, nextval(pg_get_serial_sequence('todo', 'id'))), $2::varchar, $3::varchar, $4::bytea, $5::bytea, $6::varchar, $7::boolean,
+				$1::varchar, $1::varchar IS NULL, $8::char(64), $9::bigint
+			WHERE $9::bigint IS NULL OR EXISTS (SELECT 1 FROM todo_list l WHERE l.id = $9 AND `+canEditList+`)
+			ON CONFLICT (request_key) DO UPDATE SET request_key = EXCLUDED.request_key RETURNING id`,
+			caller(ctx), item.Description, details.plaintext, details.ciphertext, details.key, details.version, item.Done, requestKey, item.ListID, id).Scan(&item.Id)
+		if errors.Is(err, pgx.ErrNoRows) && item.ListID != nil {
+			return ts.listDeniedOrMissing(ctx, int(*item.ListID))
+		}
+		return err
+	})
+	return item, err
 }
 
 func (ts *TodoStore) Update(ctx context.Context, item model.Todo) (model.Todo, error) {
-	tag, err := ts.pool.Exec(
-		ctx,
-		`UPDATE todo SET description = $1, details = $2, done = $3 where id = $4`,
-		item.Description,
-		item.Details,
-		item.Done,
-		item.Id)
-	if err != nil {
+	item.Normalize()
+	if err := item.Validate(); err != nil {
 		return item, err
 	}
-	if tag.RowsAffected() == 0 {
-		return item, model.ErrEmptyResultSet
+	details, err := ts.seal(ctx, item.Details, detailsAAD(item.Id))
+	if err != nil {
+		return item, err
 	}
-	return item, nil
+	err = ts.retry.do(ctx, true, func(ctx context.Context) error {
+		err := ts.pool.QueryRow(
+			ctx,
+			`UPDATE todo t SET description = $2, details = $3, details_ciphertext = $4, details_key = $5, details_key_version = $6, done = $7
+			WHERE t.id = $8 AND `+canEdit+` RETURNING t.list_id`,
+			caller(ctx),
+			item.Description,
+			details.plaintext,
+			details.ciphertext,
+			details.key,
+			details.version,
+			item.Done,
+			item.Id).Scan(&item.ListID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ts.deniedOrMissing(ctx, int(item.Id))
+		}
+		return err
+	})
+	return item, err
 }
 
 func (ts *TodoStore) Delete(ctx context.Context, id int) error {
-	tag, err := ts.pool.Exec(
-		ctx,
-		`DELETE FROM todo where id = $1`,
-		id)
-	if err != nil {
-		return err
-	}
-	if tag.RowsAffected() == 0 {
-		return model.ErrEmptyResultSet
-	}
-	return nil
+	return ts.retry.remove(ctx, func(ctx context.Context) error {
+		tag, err := ts.pool.Exec(
+			ctx,
+			`DELETE FROM todo t WHERE t.id = $2 AND `+canDelete,
+			caller(ctx),
+			id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ts.deniedOrMissing(ctx, id)
+		}
+		return nil
+	})
 }
 
 func (ts *TodoStore) Ping(ctx context.Context) error {
@@ -207,6 +341,9 @@ func (ts *TodoStore) Close(ctx context.Context) error {
 
 	go func() {
 		defer close(done)
+		if ts.replica != nil {
+			ts.replica.Close()
+		}
 		ts.pool.Close()
 	}()
 
@@ -0,0 +1,146 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// DefaultTokenScope is the Entra ID scope for Azure Database for PostgreSQL.
+const DefaultTokenScope = "https://ossrdbms-aad.database.windows.net/.default"
+
+// fileTokenValidity is how long a token read from a file is used if its expiry can't be
+// determined from the token itself. It must exceed refreshSkew and refreshLead, so the file
+// isn't reread continuously.
+const fileTokenValidity = 15 * time.Minute
+
+// TokenProvider acquires the access tokens used as passwords for passwordless authentication.
+type TokenProvider interface {
+	Token(ctx context.Context) (azcore.AccessToken, error)
+}
+
+// TokenConfig selects and configures a TokenProvider.
+type TokenConfig struct {
+	// Provider is one of "default", "managed-identity", "workload-identity", "static" or "file".
+	// It defaults to "default".
+	Provider string
+	// Scope defaults to DefaultTokenScope.
+	Scope    string
+	ClientID string
+	TenantID string
+	// TokenFile is the federated token file for "workload-identity" and the access token file for "file".
+	TokenFile string
+	// Token is the access token for "static".
+	Token string
+}
+
+func NewTokenProvider(cfg TokenConfig) (TokenProvider, error) {
+	scope := cfg.Scope
+	if scope == "" {
+		scope = DefaultTokenScope
+	}
+
+	var cred azcore.TokenCredential
+	var err error
+	switch strings.ToLower(cfg.Provider) {
+	case "", "default":
+		cred, err = azidentity.NewDefaultAzureCredential(nil)
+	case "managed-identity":
+		if cfg.ClientID == "" {
+			return nil, errors.New("managed identity token provider requires a client ID")
+		}
+		cred, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(cfg.ClientID),
+		})
+	case "workload-identity":
+		// Empty values fall back to the AZURE_* variables injected by the workload identity webhook.
+		cred, err = azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientID:      cfg.ClientID,
+			TenantID:      cfg.TenantID,
+			TokenFilePath: cfg.TokenFile,
+		})
+	case "static":
+		if cfg.Token == "" {
+			return nil, errors.New("static token provider requires a token")
+		}
+		return StaticToken(cfg.Token), nil
+	case "file":
+		if cfg.TokenFile == "" {
+			return nil, errors.New("file token provider requires a token file")
+		}
+		return FileToken(cfg.TokenFile), nil
+	default:
+		return nil, fmt.Errorf("unknown token provider %q", cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewCredentialTokenProvider(cred, scope), nil
+}
+
+type credentialTokenProvider struct {
+	cred azcore.TokenCredential
+	opts policy.TokenRequestOptions
+}
+
+// NewCredentialTokenProvider returns a TokenProvider requesting tokens for scope from cred.
+// The credential is reused, so it can cache tokens and connections.
+func NewCredentialTokenProvider(cred azcore.TokenCredential, scope string) TokenProvider {
+	return &credentialTokenProvider{cred: cred, opts: policy.TokenRequestOptions{Scopes: []string{scope}}}
+}
+
+func (p *credentialTokenProvider) Token(ctx context.Context) (azcore.AccessToken, error) {
+	return p.cred.GetToken(ctx, p.opts)
+}
+
+// StaticToken is a TokenProvider that always returns the same token, for tests.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: string(t), ExpiresOn: tokenExpiry(string(t), time.Now().UTC().Add(fileTokenValidity))}, nil
+}
+
+// FileToken is a TokenProvider that reads the token from a file on each call, e.g. one that
+// is kept up to date by a sidecar.
+type FileToken string
+
+func (f FileToken) Token(context.Context) (azcore.AccessToken, error) {
+	b, err := os.ReadFile(string(f))
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return azcore.AccessToken{}, fmt.Errorf("token file %q is empty", string(f))
+	}
+	return azcore.AccessToken{Token: token, ExpiresOn: tokenExpiry(token, time.Now().UTC().Add(fileTokenValidity))}, nil
+}
+
+// tokenExpiry returns the exp claim of a JWT, or fallback if token isn't a JWT with an exp claim.
+// The signature isn't verified; the token is only passed on to the server.
+func tokenExpiry(token string, fallback time.Time) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return fallback
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fallback
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return fallback
+	}
+	return time.Unix(claims.Exp, 0).UTC()
+}
@@ -0,0 +1,154 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/jackc/pgx/v5"
+)
+
+type countingProvider struct {
+	calls int
+	token azcore.AccessToken
+}
+
+func (p *countingProvider) Token(context.Context) (azcore.AccessToken, error) {
+	p.calls++
+	return p.token, nil
+}
+
+func TestTokenExpiry(t *testing.T) {
+	fallback := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	exp := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"exp":1924992000}`))
+	tests := []struct {
+		name  string
+		token string
+		want  time.Time
+	}{
+		{name: "jwt", token: "e30." + payload + ".sig", want: exp},
+		{name: "opaque", token: "secret", want: fallback},
+		{name: "invalid_payload", token: "e30.!.sig", want: fallback},
+		{name: "no_exp", token: "e30.e30.sig", want: fallback},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tokenExpiry(tc.token, fallback); !got.Equal(tc.want) {
+				t.Errorf("Want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFileToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if _, err := FileToken(path).Token(context.Background()); err == nil {
+		t.Error("Want error for missing file")
+	}
+	for _, token := range []string{"first", "second"} {
+		if err := os.WriteFile(path, []byte(token+"\n"), 0o600); err != nil {
+			t.Fatalf("Fatal error writing token: %v", err)
+		}
+		at, err := FileToken(path).Token(context.Background())
+		if err != nil {
+			t.Fatalf("Fatal error reading token: %v", err)
+		}
+		if at.Token != token {
+			t.Errorf("Want %q, got %q", token, at.Token)
+		}
+	}
+}
+
+func TestNewTokenProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     TokenConfig
+		wantErr bool
+	}{
+		{name: "static", cfg: TokenConfig{Provider: "static", Token: "secret"}},
+		{name: "static_without_token", cfg: TokenConfig{Provider: "static"}, wantErr: true},
+		{name: "file", cfg: TokenConfig{Provider: "file", TokenFile: "/var/run/token"}},
+		{name: "file_without_path", cfg: TokenConfig{Provider: "file"}, wantErr: true},
+		{name: "managed_identity", cfg: TokenConfig{Provider: "managed-identity", ClientID: "00000000-0000-0000-0000-000000000000"}},
+		{name: "managed_identity_without_client_id", cfg: TokenConfig{Provider: "managed-identity"}, wantErr: true},
+		{name: "unknown", cfg: TokenConfig{Provider: "kerberos"}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewTokenProvider(tc.cfg); (err != nil) != tc.wantErr {
+				t.Errorf("Want error %v, got error %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTokenProviderForPassword(t *testing.T) {
+	configured := &countingProvider{}
+	tests := []struct {
+		name         string
+		configured   TokenProvider
+		passwordless bool
+		want         TokenProvider
+		wantDefault  bool
+	}{
+		{name: "password", configured: configured},
+		{name: "password_without_provider"},
+		{name: "passwordless", configured: configured, passwordless: true, want: configured},
+		{name: "passwordless_default", passwordless: true, wantDefault: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tokenProvider(context.Background(), tc.configured, tc.passwordless)
+			if err != nil {
+				t.Fatalf("Want no error, got %v", err)
+			}
+			if tc.wantDefault {
+				if got == nil {
+					t.Error("Want default token provider, got nil")
+				}
+			} else if got != tc.want {
+				t.Errorf("Want token provider %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBeforeConnect(t *testing.T) {
+	p := &countingProvider{token: azcore.AccessToken{Token: "token", ExpiresOn: time.Now().Add(time.Hour)}}
+	ts := TodoStore{tokens: p}
+
+	for range 3 {
+		var cfg pgx.ConnConfig
+		if err := ts.beforeConnect(context.Background(), &cfg); err != nil {
+			t.Fatalf("Fatal error in beforeConnect: %v", err)
+		}
+		if cfg.Password != "token" {
+			t.Errorf("Want password %q, got %q", "token", cfg.Password)
+		}
+	}
+	if p.calls != 1 {
+		t.Errorf("Want token to be acquired once, got %d calls", p.calls)
+	}
+
+	// Tokens within the refresh skew are renewed.
+	ts.accessToken.ExpiresOn = time.Now().Add(time.Minute)
+	var cfg pgx.ConnConfig
+	if err := ts.beforeConnect(context.Background(), &cfg); err != nil {
+		t.Fatalf("Fatal error in beforeConnect: %v", err)
+	}
+	if p.calls != 2 {
+		t.Errorf("Want token to be renewed, got %d calls", p.calls)
+	}
+
+	// Passwords from the connection string take precedence.
+	cfg = pgx.ConnConfig{}
+	cfg.Password = "password"
+	if err := ts.beforeConnect(context.Background(), &cfg); err != nil || cfg.Password != "password" {
+		t.Errorf("Want password to be kept, got %q, error %v", cfg.Password, err)
+	}
+}
@@ -0,0 +1,79 @@
+package postgres
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/postgres"
+
+var (
+	// literalPattern matches parameters, string and numeric literals. Only literals are replaced.
+	literalPattern    = regexp.MustCompile(`\$\d+|'(?:[^']|'')*'|\b\d+(?:\.\d+)?\b`)
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// sanitize replaces literals in a statement with ? and collapses white space. Arguments are
+// never recorded, so only literals embedded in the SQL could leak data into traces.
+func sanitize(sql string) string {
+	sql = literalPattern.ReplaceAllStringFunc(sql, func(m string) string {
+		if strings.HasPrefix(m, "$") {
+			return m
+		}
+		return "?"
+	})
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(sql, " "))
+}
+
+// operation returns the leading keyword of a statement, e.g. SELECT.
+func operation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// queryTracer creates a client span for each query. It uses the global tracer provider, so it
+// is a no-op unless tracing has been set up.
+type queryTracer struct{}
+
+var _ pgx.QueryTracer = queryTracer{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	op := operation(data.SQL)
+	name := op
+	attrs := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName(op),
+			semconv.DBQueryText(sanitize(data.SQL))),
+	}
+	if conn != nil {
+		cfg := conn.Config()
+		name += " " + cfg.Database
+		attrs = append(attrs, trace.WithAttributes(
+			semconv.DBNamespace(cfg.Database),
+			semconv.ServerAddress(cfg.Host),
+			semconv.ServerPort(int(cfg.Port))))
+	}
+	ctx, _ = otel.Tracer(tracerName).Start(ctx, name, attrs...)
+	return ctx
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,37 @@
+package postgres
+
+import "testing"
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{
+			sql:  "SELECT t.id FROM todo t\n\tWHERE t.id = $2 AND a.role = 'editor'",
+			want: "SELECT t.id FROM todo t WHERE t.id = $2 AND a.role = ?",
+		},
+		{
+			sql:  "SELECT 1 FROM todo WHERE description = 'it''s secret' LIMIT 10",
+			want: "SELECT ? FROM todo WHERE description = ? LIMIT ?",
+		},
+		{
+			sql:  "UPDATE todo_acl SET role = $1",
+			want: "UPDATE todo_acl SET role = $1",
+		},
+	}
+	for _, tc := range tests {
+		if got := sanitize(tc.sql); got != tc.want {
+			t.Errorf("Want %q, got %q", tc.want, got)
+		}
+	}
+}
+
+func TestOperation(t *testing.T) {
+	if got := operation("\n  select 1"); got != "SELECT" {
+		t.Errorf("Want %q, got %q", "SELECT", got)
+	}
+	if got := operation(""); got != "" {
+		t.Errorf("Want empty operation, got %q", got)
+	}
+}
@@ -0,0 +1,53 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type Memory struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	idle      time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+var _ Backend = (*Memory)(nil)
+
+// NewMemory returns an in-process backend. Buckets that have not been used for idle are
+// evicted so that the number of tracked clients stays bounded.
+func NewMemory(idle time.Duration) *Memory {
+	return &Memory{
+		buckets: make(map[string]*bucket),
+		idle:    idle,
+		now:     time.Now,
+	}
+}
+
+func (m *Memory) Allow(_ context.Context, key string, l Limit) (Result, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		m.buckets[key] = b
+	}
+	return b.take(now, l), nil
+}
+
+func (m *Memory) sweep(now time.Time) {
+	if m.idle <= 0 || now.Sub(m.lastSweep) < m.idle {
+		return
+	}
+	for k, b := range m.buckets {
+		if now.Sub(b.last) >= m.idle {
+			delete(m.buckets, k)
+		}
+	}
+	m.lastSweep = now
+}
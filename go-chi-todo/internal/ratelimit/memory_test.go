@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryAllow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory(time.Minute)
+	m.now = func() time.Time { return now }
+	l := Limit{Rate: 1, Burst: 2}
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		advance   time.Duration
+		allowed   bool
+		remaining int
+	}{
+		{name: "first", advance: 0, allowed: true, remaining: 1},
+		{name: "burst", advance: 0, allowed: true, remaining: 0},
+		{name: "exhausted", advance: 0, allowed: false, remaining: 0},
+		{name: "half_refill", advance: 500 * time.Millisecond, allowed: false, remaining: 0},
+		{name: "refilled", advance: 500 * time.Millisecond, allowed: true, remaining: 0},
+		{name: "full_after_idle", advance: 10 * time.Second, allowed: true, remaining: 1},
+	}
+	for _, tc := range tests {
+		now = now.Add(tc.advance)
+		res, err := m.Allow(ctx, "client", l)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if res.Allowed != tc.allowed {
+			t.Errorf("%s: want allowed %v, got %v", tc.name, tc.allowed, res.Allowed)
+		}
+		if res.Remaining != tc.remaining {
+			t.Errorf("%s: want remaining %d, got %d", tc.name, tc.remaining, res.Remaining)
+		}
+		if !res.Allowed && res.RetryAfter <= 0 {
+			t.Errorf("%s: want positive RetryAfter, got %v", tc.name, res.RetryAfter)
+		}
+	}
+}
+
+func TestMemoryKeysAreIndependent(t *testing.T) {
+	m := NewMemory(time.Minute)
+	l := Limit{Rate: 1, Burst: 1}
+	ctx := context.Background()
+	if res, _ := m.Allow(ctx, "a", l); !res.Allowed {
+		t.Fatal("want first request for a to be allowed")
+	}
+	if res, _ := m.Allow(ctx, "a", l); res.Allowed {
+		t.Fatal("want second request for a to be rejected")
+	}
+	if res, _ := m.Allow(ctx, "b", l); !res.Allowed {
+		t.Fatal("want first request for b to be allowed")
+	}
+}
+
+func TestMemoryEvictsIdleBuckets(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewMemory(time.Minute)
+	m.now = func() time.Time { return now }
+	l := Limit{Rate: 1, Burst: 1}
+	ctx := context.Background()
+	m.Allow(ctx, "a", l)
+	now = now.Add(2 * time.Minute)
+	m.Allow(ctx, "b", l)
+	if _, ok := m.buckets["a"]; ok {
+		t.Error("want idle bucket to be evicted")
+	}
+	if len(m.buckets) != 1 {
+		t.Errorf("want 1 bucket, got %d", len(m.buckets))
+	}
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket that refills at Rate tokens per second up to Burst tokens.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Time until the bucket is full again.
+	RetryAfter time.Duration // Time until the next request is allowed, zero if Allowed.
+}
+
+// Backend stores rate limit state. Implementations must be safe for concurrent use.
+// The in-memory backend only limits a single replica; a shared backend (e.g. Redis)
+// can be plugged in to enforce limits across replicas.
+type Backend interface {
+	Allow(ctx context.Context, key string, l Limit) (Result, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time elapsed since the last call and tries to
+// take a single token.
+func (b *bucket) take(now time.Time, l Limit) Result {
+	burst := float64(l.Burst)
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*l.Rate)
+		b.last = now
+	}
+
+	res := Result{Limit: l.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / l.Rate)
+	}
+	res.Remaining = int(math.Floor(b.tokens))
+	res.Reset = seconds((burst - b.tokens) / l.Rate)
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+)
+
+// accessLog writes one record per request. Errors and requests slower than slow are always
+// logged, other requests with probability sampleRate. Successful probes and scrapes are
+// skipped.
+func accessLog(sampleRate float64, slow time.Duration, hops int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			logged := &loggedPrincipal{}
+			next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), loggedPrincipalKey{}, logged)))
+			elapsed := time.Since(start)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			level := slog.LevelInfo
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case slow > 0 && elapsed >= slow:
+				level = slog.LevelWarn
+			case status >= http.StatusBadRequest:
+			case strings.HasPrefix(r.URL.Path, "/healthz/") || r.URL.Path == "/metrics":
+				return
+			case sampleRate < 1 && rand.Float64() >= sampleRate:
+				return
+			}
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String(log.RouteKey, routeLabel(r)),
+				slog.Int("status", status),
+				slog.Int("bytes", ww.BytesWritten()),
+				slog.Duration("duration", elapsed),
+				slog.String("clientIp", clientIP(r, hops)),
+				slog.String("userAgent", r.UserAgent()),
+			}
+			if logged.id != "" {
+				attrs = append(attrs, slog.String(log.PrincipalKey, logged.id))
+			}
+			slog.LogAttrs(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+// loggedPrincipal carries the verified principal back up to accessLog, since it's only added
+// to the request context further down the chain. Requests rejected before that have none.
+type loggedPrincipal struct {
+	id string
+}
+
+type loggedPrincipalKey struct{}
+
+// logPrincipal records id for the access log, unless a principal has been recorded already.
+func logPrincipal(ctx context.Context, id string) {
+	if l, ok := ctx.Value(loggedPrincipalKey{}).(*loggedPrincipal); ok && l.id == "" {
+		l.id = id
+	}
+}
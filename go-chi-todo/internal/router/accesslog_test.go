@@ -0,0 +1,133 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(log.NewContextHandler(slog.NewJSONHandler(&buf, nil))))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	ts := &mockTodoStore{
+		findFn: func(ctx context.Context, id int) (model.Todo, error) {
+			if id == 2 {
+				time.Sleep(20 * time.Millisecond)
+			}
+			return model.Todo{Id: int64(id)}, nil
+		},
+		pingFn: func(ctx context.Context) error {
+			return nil
+		},
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		sampleRate float64
+		want       bool
+		level      string
+		status     float64
+	}{
+		{"sampled", "/todo/1", 1, true, "INFO", http.StatusOK},
+		{"not_sampled", "/todo/1", 0, false, "", 0},
+		{"error", "/todo/abc", 0, true, "INFO", http.StatusNotFound},
+		{"slow", "/todo/2", 0, true, "WARN", http.StatusOK},
+		{"probe", "/healthz/ready", 1, false, "", 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			mux := NewMux(ts, WithAccessLog(tc.sampleRate, 10*time.Millisecond), WithTrustedProxyHops(1))
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.Header.Set("User-Agent", "test-agent")
+			mux.ServeHTTP(httptest.NewRecorder(), r)
+
+			var got map[string]any
+			for line := range strings.SplitSeq(strings.TrimSpace(buf.String()), "\n") {
+				var rec map[string]any
+				if err := json.Unmarshal([]byte(line), &rec); err == nil && rec["msg"] == "request" {
+					got = rec
+				}
+			}
+			if (got != nil) != tc.want {
+				t.Fatalf("Want access log %v, got %v", tc.want, got)
+			}
+			if !tc.want {
+				return
+			}
+			if got["level"] != tc.level || got["status"] != tc.status {
+				t.Errorf("Want level %s and status %v, got %v and %v", tc.level, tc.status, got["level"], got["status"])
+			}
+			if got["clientIp"] != "203.0.113.7" || got["userAgent"] != "test-agent" || got["method"] != "GET" {
+				t.Errorf("Want client details in access log, got %v", got)
+			}
+			if got[log.RequestIDKey] == nil {
+				t.Errorf("Want request id in access log, got %v", got)
+			}
+		})
+	}
+}
+
+func TestAccessLogPrincipal(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	ts := &mockTodoStore{
+		findFn: func(ctx context.Context, id int) (model.Todo, error) {
+			return model.Todo{Id: int64(id)}, nil
+		},
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		opts        []Option
+		want        string
+	}{
+		{"trusted", http.MethodGet, "", []Option{WithEasyAuth()}, "alice"},
+		{"untrusted", http.MethodGet, "", nil, ""},
+		{"rejected_before_authenticate", http.MethodPost, "text/plain", []Option{WithEasyAuth()}, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			mux := NewMux(ts, append(tc.opts, WithAccessLog(1, 0))...)
+			r := httptest.NewRequest(tc.method, "/todo/1", nil)
+			if tc.contentType != "" {
+				r = httptest.NewRequest(tc.method, "/todo", strings.NewReader("{}"))
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			r.Header.Set(auth.PrincipalIDHeader, "alice")
+			mux.ServeHTTP(httptest.NewRecorder(), r)
+
+			var got string
+			for line := range strings.SplitSeq(strings.TrimSpace(buf.String()), "\n") {
+				var rec map[string]any
+				if err := json.Unmarshal([]byte(line), &rec); err == nil && rec["msg"] == "request" {
+					got, _ = rec[log.PrincipalKey].(string)
+				}
+			}
+			if got != tc.want {
+				t.Errorf("Want principal %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
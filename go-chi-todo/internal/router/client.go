@@ -0,0 +1,117 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+// clientIP returns the address of the client that connected to the outermost trusted
+// proxy. Each proxy appends the address it received the request from to X-Forwarded-For,
+// so only the last hops entries can be trusted.
+func clientIP(r *http.Request, hops int) string {
+	if hops > 0 {
+		var addrs []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			for a := range strings.SplitSeq(v, ",") {
+				if a = strings.TrimSpace(a); a != "" {
+					addrs = append(addrs, a)
+				}
+			}
+		}
+		if len(addrs) > 0 {
+			i := max(len(addrs)-hops, 0)
+			return addrs[i]
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// apiKeys holds hashes of the configured API keys, so the keys themselves are never kept
+// in memory or sent to a shared backend.
+type apiKeys map[string]struct{}
+
+func newAPIKeys(keys []string) apiKeys {
+	h := make(apiKeys, len(keys))
+	for _, k := range keys {
+		h[hashAPIKey(k)] = struct{}{}
+	}
+	return h
+}
+
+func hashAPIKey(k string) string {
+	sum := sha256.Sum256([]byte(k))
+	return hex.EncodeToString(sum[:16])
+}
+
+// clientKey identifies the caller for rate limiting, preferring the authenticated
+// principal over a configured API key over the client's IP address. Unknown API keys are
+// ignored, otherwise clients could get a fresh bucket for every request.
+func clientKey(r *http.Request, hops int, keys apiKeys) string {
+	if p, ok := auth.FromContext(r.Context()); ok {
+		return "principal:" + p.ID
+	}
+	if k := r.Header.Get(apiKeyHeader); k != "" {
+		if h := hashAPIKey(k); keys.contains(h) {
+			return "apikey:" + h
+		}
+	}
+	return "ip:" + clientIP(r, hops)
+}
+
+func (k apiKeys) contains(hash string) bool {
+	_, ok := k[hash]
+	return ok
+}
+
+// authenticate adds the Easy Auth principal to the request context if trustEasyAuth is set.
+// Otherwise the principal headers are removed, since any client can send them.
+func authenticate(trustEasyAuth bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !trustEasyAuth {
+				r.Header.Del(auth.PrincipalIDHeader)
+				r.Header.Del(auth.PrincipalNameHeader)
+				r.Header.Del(auth.PrincipalProviderHeader)
+			} else if p, ok := auth.FromRequest(r); ok {
+				r = r.WithContext(auth.NewContext(r.Context(), p))
+				logPrincipal(r.Context(), p.ID)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requirePrincipal rejects requests without a verified principal.
+func requirePrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := auth.FromContext(r.Context()); !ok {
+			writeError(w, r, model.ErrUnauthenticated)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientSubject exposes the subject of a verified client certificate to handlers.
+func clientSubject(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			subject := r.TLS.VerifiedChains[0][0].Subject.String()
+			r = r.WithContext(auth.NewSubjectContext(r.Context(), subject))
+			logPrincipal(r.Context(), subject)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+)
+
+func TestClientSubject(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "client", Organization: []string{"Contoso"}}}
+	tests := []struct {
+		name   string
+		state  *tls.ConnectionState
+		want   string
+		wantOk bool
+	}{
+		{
+			name:  "plain_http",
+			state: nil,
+		},
+		{
+			name:  "unverified_certificate",
+			state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+		},
+		{
+			name:   "verified_certificate",
+			state:  &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert}}},
+			want:   "CN=client,O=Contoso",
+			wantOk: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got string
+			var ok bool
+			h := clientSubject(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, ok = auth.SubjectFromContext(r.Context())
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/todo", nil)
+			r.TLS = tc.state
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if ok != tc.wantOk || got != tc.want {
+				t.Errorf("Want subject %q (%v), got %q (%v)", tc.want, tc.wantOk, got, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// readYourWritesHeader lets clients that just changed a todo read it back from the primary
+// database instead of a possibly lagging read replica.
+const readYourWritesHeader = "X-Read-Your-Writes"
+
+func readYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v, err := strconv.ParseBool(r.Header.Get(readYourWritesHeader)); err == nil && v {
+			r = r.WithContext(model.NewReadYourWritesContext(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
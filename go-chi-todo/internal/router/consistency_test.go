@@ -0,0 +1,43 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  bool
+	}{
+		{name: "absent", value: "", want: false},
+		{name: "true", value: "true", want: true},
+		{name: "one", value: "1", want: true},
+		{name: "false", value: "false", want: false},
+		{name: "invalid", value: "always", want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got bool
+			ts := &mockTodoStore{
+				findFn: func(ctx context.Context, id int) (model.Todo, error) {
+					got = model.ReadYourWritesFromContext(ctx)
+					return model.Todo{Id: int64(id)}, nil
+				},
+			}
+			r := httptest.NewRequest(http.MethodGet, "/todo/1", nil)
+			if tc.value != "" {
+				r.Header.Set(readYourWritesHeader, tc.value)
+			}
+			NewMux(ts).ServeHTTP(httptest.NewRecorder(), r)
+			if got != tc.want {
+				t.Errorf("Want read-your-writes %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
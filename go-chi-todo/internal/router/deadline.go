@@ -0,0 +1,39 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// paramPattern matches the regular expressions of URL parameters such as {id:[0-9]+}.
+var paramPattern = regexp.MustCompile(`:[^}]*}`)
+
+// routeKey identifies a route as "<method> <pattern>", e.g. "GET /todo/{id}".
+func routeKey(r *http.Request) string {
+	pattern := chi.RouteContext(r.Context()).RoutePattern()
+	return r.Method + " " + paramPattern.ReplaceAllString(pattern, "}")
+}
+
+// deadline limits how long handlers may wait for the database. routes overrides the default
+// timeout for specific routes.
+func deadline(timeout time.Duration, routes map[string]time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := timeout
+			if rd, ok := routes[routeKey(r)]; ok {
+				d = rd
+			}
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+func TestDeadline(t *testing.T) {
+	var remaining time.Duration
+	record := func(ctx context.Context) {
+		remaining = 0
+		if d, ok := ctx.Deadline(); ok {
+			remaining = time.Until(d)
+		}
+	}
+	ts := &mockTodoStore{
+		findFn: func(ctx context.Context, id int) (model.Todo, error) {
+			record(ctx)
+			return model.Todo{Id: int64(id)}, nil
+		},
+		listFn: func(ctx context.Context, offset int, limit int) ([]model.Todo, error) {
+			record(ctx)
+			return nil, nil
+		},
+	}
+	mux := NewMux(ts, WithDBTimeouts(time.Second, map[string]time.Duration{"GET /todo/{id}": time.Minute}))
+
+	tests := []struct {
+		path string
+		want time.Duration
+	}{
+		{path: "/todo", want: time.Second},
+		{path: "/todo/1", want: time.Minute},
+	}
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if remaining <= tc.want-time.Second/2 || remaining > tc.want {
+				t.Errorf("Want deadline in %v, got %v", tc.want, remaining)
+			}
+		})
+	}
+}
+
+func TestTimeoutProblem(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "deadline", err: fmt.Errorf("querying: %w", context.DeadlineExceeded)},
+		{name: "statement_timeout", err: fmt.Errorf("%w: canceling statement", model.ErrTimeout)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := &mockTodoStore{
+				findFn: func(ctx context.Context, id int) (model.Todo, error) {
+					return model.Todo{}, tc.err
+				},
+			}
+			w := httptest.NewRecorder()
+			NewMux(ts).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todo/1", nil))
+			if w.Code != http.StatusGatewayTimeout {
+				t.Errorf("Want status code %d, got %d", http.StatusGatewayTimeout, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	maxIdempotencyKeyLen = 255
+)
+
+// replayedHeaders are the response headers stored and replayed alongside the body.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+func idempotent(is model.IdempotencyStore, ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				writeError(w, r, newProblem(http.StatusBadRequest, fmt.Sprintf("The Idempotency-Key must not be longer than %d characters.", maxIdempotencyKeyLen)))
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+			r.Body.Close()
+			if err != nil {
+				writeError(w, r, &bindError{err: err})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			key = scopedKey(r, key)
+			hash := requestHash(r, body)
+			rec, reserved, err := is.Reserve(r.Context(), key, hash, ttl)
+			if err != nil {
+				writeError(w, r, fmt.Errorf("reserving idempotency key: %w", err))
+				return
+			}
+			if !reserved {
+				replay(w, r, rec, hash)
+				return
+			}
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			var buf bytes.Buffer
+			ww.Tee(&buf)
+			r = r.WithContext(model.NewIdempotencyKeyContext(r.Context(), key))
+			next.ServeHTTP(ww, r)
+
+			// Store the outcome even if the client has gone away, that's when it will retry.
+			ctx := context.WithoutCancel(r.Context())
+			status := ww.Status()
+			if status == 0 || status >= http.StatusInternalServerError {
+				// Let the client retry requests that failed on our end.
+				if err := is.Release(ctx, key); err != nil {
+					slog.ErrorContext(ctx, "releasing idempotency key", log.ErrorKey, err)
+				}
+				return
+			}
+			res := model.IdempotentResponse{Status: status, Header: map[string]string{}, Body: buf.Bytes()}
+			for _, h := range replayedHeaders {
+				if v := ww.Header().Get(h); v != "" {
+					res.Header[h] = v
+				}
+			}
+			if err := is.Complete(ctx, key, res); err != nil {
+				slog.ErrorContext(ctx, "storing idempotent response", log.ErrorKey, err)
+				if err := is.Release(ctx, key); err != nil {
+					slog.ErrorContext(ctx, "releasing idempotency key", log.ErrorKey, err)
+				}
+			}
+		})
+	}
+}
+
+func replay(w http.ResponseWriter, r *http.Request, rec model.IdempotencyRecord, hash []byte) {
+	if !bytes.Equal(rec.RequestHash, hash) {
+		p := newProblem(http.StatusConflict, "The Idempotency-Key has already been used for a different request.")
+		p.Type = problemTypeIdempotency
+		writeError(w, r, p)
+		return
+	}
+	if rec.Response == nil {
+		p := newProblem(http.StatusConflict, "A request with this Idempotency-Key is still being processed.")
+		p.Type = problemTypeInFlight
+		p.headers = []header{{name: "Retry-After", val: "1"}}
+		writeError(w, r, p)
+		return
+	}
+	for k, v := range rec.Response.Header {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(rec.Response.Status)
+	w.Write(rec.Response.Body)
+}
+
+// scopedKey prevents callers from replaying each other's responses by guessing keys.
+func scopedKey(r *http.Request, key string) string {
+	var scope string
+	if p, ok := auth.FromContext(r.Context()); ok {
+		scope = p.ID
+	}
+	h := sha256.New()
+	io.WriteString(h, scope)
+	h.Write([]byte{0})
+	io.WriteString(h, key)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func requestHash(r *http.Request, body []byte) []byte {
+	h := sha256.New()
+	io.WriteString(h, r.Method)
+	h.Write([]byte{0})
+	io.WriteString(h, r.URL.Path)
+	h.Write([]byte{0})
+	h.Write(body)
+	return h.Sum(nil)
+}
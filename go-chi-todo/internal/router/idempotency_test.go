@@ -0,0 +1,181 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+type memIdempotencyStore struct {
+	mutex   sync.Mutex
+	records map[string]model.IdempotencyRecord
+}
+
+func newMemIdempotencyStore() *memIdempotencyStore {
+	return &memIdempotencyStore{records: make(map[string]model.IdempotencyRecord)}
+}
+
+func (m *memIdempotencyStore) Reserve(ctx context.Context, key string, requestHash []byte, ttl time.Duration) (model.IdempotencyRecord, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if rec, ok := m.records[key]; ok {
+		return rec, false, nil
+	}
+	rec := model.IdempotencyRecord{RequestHash: requestHash}
+	m.records[key] = rec
+	return rec, true, nil
+}
+
+func (m *memIdempotencyStore) Complete(ctx context.Context, key string, res model.IdempotentResponse) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rec := m.records[key]
+	rec.Response = &res
+	m.records[key] = rec
+	return nil
+}
+
+func (m *memIdempotencyStore) Release(ctx context.Context, key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+func postTodo(mux http.Handler, key string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/todo", bytes.NewBufferString(body))
+	r.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		r.Header.Set(idempotencyKeyHeader, key)
+	}
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotentPost(t *testing.T) {
+	var calls atomic.Int64
+	var fail atomic.Bool
+	ts := &mockTodoStore{
+		createFn: func(ctx context.Context, item model.Todo) (model.Todo, error) {
+			if fail.Load() {
+				return item, errors.New("test error")
+			}
+			item.Id = calls.Add(1)
+			return item, nil
+		},
+	}
+	mux := NewMux(ts, WithIdempotency(newMemIdempotencyStore(), time.Hour))
+	body := `{"description":"test","details":"a test","done":false}`
+
+	first := postTodo(mux, "key-1", body)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Want status code %d, got %d", http.StatusCreated, first.Code)
+	}
+	retry := postTodo(mux, "key-1", body)
+	if retry.Code != http.StatusCreated {
+		t.Fatalf("Want replayed status code %d, got %d", http.StatusCreated, retry.Code)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("Want replayed body %q, got %q", first.Body.String(), retry.Body.String())
+	}
+	if got, want := retry.Header().Get("Location"), first.Header().Get("Location"); got != want {
+		t.Errorf("Want replayed Location %q, got %q", want, got)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("Want Idempotent-Replayed header")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Want 1 call to Create, got %d", n)
+	}
+
+	if w := postTodo(mux, "key-1", `{"description":"other","details":"","done":false}`); w.Code != http.StatusConflict {
+		t.Errorf("Want status code %d for different body, got %d", http.StatusConflict, w.Code)
+	}
+
+	// Server errors release the key so the client can retry.
+	fail.Store(true)
+	if w := postTodo(mux, "key-2", body); w.Code != http.StatusInternalServerError {
+		t.Fatalf("Want status code %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+	fail.Store(false)
+	if w := postTodo(mux, "key-2", body); w.Code != http.StatusCreated {
+		t.Errorf("Want status code %d after failed attempt, got %d", http.StatusCreated, w.Code)
+	}
+
+	// Requests without a key are never deduplicated.
+	before := calls.Load()
+	postTodo(mux, "", body)
+	postTodo(mux, "", body)
+	if n := calls.Load() - before; n != 2 {
+		t.Errorf("Want 2 calls to Create without key, got %d", n)
+	}
+}
+
+func TestIdempotentPostInFlight(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	var calls atomic.Int64
+	ts := &mockTodoStore{
+		createFn: func(ctx context.Context, item model.Todo) (model.Todo, error) {
+			if calls.Add(1) == 1 {
+				close(started)
+				<-unblock
+			}
+			return item, nil
+		},
+	}
+	mux := NewMux(ts, WithIdempotency(newMemIdempotencyStore(), time.Hour))
+	body := `{"description":"test","details":"a test","done":false}`
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- postTodo(mux, "key", body)
+	}()
+	<-started
+	w := postTodo(mux, "key", body)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Want status code %d for in-flight duplicate, got %d", http.StatusConflict, w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Want Retry-After header for in-flight duplicate")
+	}
+	close(unblock)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Errorf("Want status code %d for original request, got %d", http.StatusCreated, w.Code)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Want 1 call to Create, got %d", n)
+	}
+}
+
+func TestIdempotencyKeyContext(t *testing.T) {
+	var keys []string
+	ts := &mockTodoStore{
+		createFn: func(ctx context.Context, item model.Todo) (model.Todo, error) {
+			if key, ok := model.IdempotencyKeyFromContext(ctx); ok {
+				keys = append(keys, key)
+			}
+			return item, nil
+		},
+	}
+	mux := NewMux(ts, WithIdempotency(newMemIdempotencyStore(), time.Hour))
+	body := `{"description":"test","details":"","done":false}`
+
+	postTodo(mux, "", body)
+	if len(keys) != 0 {
+		t.Fatalf("Want no idempotency key without header, got %v", keys)
+	}
+	postTodo(mux, "key-1", body)
+	if len(keys) != 1 || len(keys[0]) != 64 {
+		t.Errorf("Want scoped idempotency key in context, got %v", keys)
+	}
+}
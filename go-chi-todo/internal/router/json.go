@@ -6,30 +6,45 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
 )
 
+// unknownFieldError is returned for fields rejected by DisallowUnknownFields. encoding/json
+// only returns an unexported error type for these.
+type unknownFieldError struct {
+	field string
+}
+
+func (e *unknownFieldError) Error() string {
+	return "json: unknown field " + e.field
+}
+
 func bind(r *http.Request, v any) error {
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
 	if err := dec.Decode(v); err != nil {
-		return err
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			err = &unknownFieldError{field: field}
+		}
+		return &bindError{err: err}
 	}
 	// Ensure the body contains only a single JSON value.
 	if err := dec.Decode(&struct{}{}); err != io.EOF {
 		if err == nil {
-			return fmt.Errorf("request body must contain only a single JSON value")
+			err = fmt.Errorf("request body must contain only a single JSON value")
 		}
-		return err
+		return &bindError{err: err}
 	}
 	return nil
 }
 
-func respond(w http.ResponseWriter, data any, status int, headers ...header) {
+func respond(w http.ResponseWriter, r *http.Request, data any, status int, headers ...header) {
 	b, err := json.Marshal(data)
 	if err != nil {
-		slog.Error("encoding response", log.ErrorKey, err, slog.String("type", fmt.Sprintf("%T", data)))
+		slog.ErrorContext(r.Context(), "encoding response", log.ErrorKey, err, slog.String("type", fmt.Sprintf("%T", data)))
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
@@ -41,3 +56,23 @@ func respond(w http.ResponseWriter, data any, status int, headers ...header) {
 	w.WriteHeader(status)
 	w.Write(b)
 }
+
+// allowContentType rejects request bodies that are not of one of the given media types.
+func allowContentType(contentTypes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mt, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+			mt = strings.ToLower(strings.TrimSpace(mt))
+			if slices.Contains(contentTypes, mt) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			detail := fmt.Sprintf("The request body must be of type %s.", strings.Join(contentTypes, " or "))
+			writeError(w, r, newProblem(http.StatusUnsupportedMediaType, detail))
+		})
+	}
+}
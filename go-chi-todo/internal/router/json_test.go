@@ -51,7 +51,7 @@ func TestRespond(t *testing.T) {
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			rec := httptest.NewRecorder()
-			respond(rec, tc.data, http.StatusOK, tc.header...)
+			respond(rec, httptest.NewRequest(http.MethodGet, "/", nil), tc.data, http.StatusOK, tc.header...)
 			res := rec.Result()
 			if res.StatusCode != http.StatusOK {
 				t.Errorf("Want HTTP 200 OK, got %v", rec.Code)
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+func getListsHandler(ls model.ListStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset, limit := pageParams(r)
+		lists, err := ls.Lists(r.Context(), offset, limit)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("reading lists from store: %w", err))
+			return
+		}
+		respond(w, r, lists, http.StatusOK)
+	}
+}
+
+func getListHandler(ls model.ListStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := idParam(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		l, err := ls.FindList(r.Context(), id)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("reading list from store: %w", err))
+			return
+		}
+		respond(w, r, l, http.StatusOK)
+	}
+}
+
+func postListHandler(ls model.ListStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
+		var l model.List
+		if err := bind(r, &l); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		l.Normalize()
+		if err := l.Validate(); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		l, err := ls.CreateList(r.Context(), l)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("creating new list in store: %w", err))
+			return
+		}
+		loc := fmt.Sprintf("%s/%d", r.URL.String(), l.Id)
+		respond(w, r, l, http.StatusCreated, header{name: "Location", val: loc})
+	}
+}
+
+func deleteListHandler(ls model.ListStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := idParam(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := ls.DeleteList(r.Context(), id); err != nil {
+			writeError(w, r, fmt.Errorf("deleting list from store: %w", err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getListTodosHandler(ls model.ListStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := idParam(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		offset, limit := pageParams(r)
+		items, err := ls.ListTodos(r.Context(), id, offset, limit)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("reading list items from store: %w", err))
+			return
+		}
+		respond(w, r, items, http.StatusOK)
+	}
+}
+
+// listCollaborators serves the collaborators of lists through the handlers for todos.
+type listCollaborators struct {
+	ls model.ListStore
+}
+
+func (c listCollaborators) Collaborators(ctx context.Context, listID int) ([]model.Collaborator, error) {
+	return c.ls.ListCollaborators(ctx, listID)
+}
+
+func (c listCollaborators) Grant(ctx context.Context, listID int, collaborator model.Collaborator) error {
+	return c.ls.GrantList(ctx, listID, collaborator)
+}
+
+func (c listCollaborators) Revoke(ctx context.Context, listID int, principal string) error {
+	return c.ls.RevokeList(ctx, listID, principal)
+}
@@ -0,0 +1,196 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+type mockListStore struct {
+	listsFn         func(ctx context.Context, offset int, limit int) ([]model.List, error)
+	findFn          func(ctx context.Context, id int) (model.List, error)
+	createFn        func(ctx context.Context, l model.List) (model.List, error)
+	deleteFn        func(ctx context.Context, id int) error
+	todosFn         func(ctx context.Context, listID int, offset int, limit int) ([]model.Todo, error)
+	collaboratorsFn func(ctx context.Context, listID int) ([]model.Collaborator, error)
+	grantFn         func(ctx context.Context, listID int, c model.Collaborator) error
+	revokeFn        func(ctx context.Context, listID int, principal string) error
+}
+
+func (m *mockListStore) Lists(ctx context.Context, offset int, limit int) ([]model.List, error) {
+	return m.listsFn(ctx, offset, limit)
+}
+
+func (m *mockListStore) FindList(ctx context.Context, id int) (model.List, error) {
+	return m.findFn(ctx, id)
+}
+
+func (m *mockListStore) CreateList(ctx context.Context, l model.List) (model.List, error) {
+	return m.createFn(ctx, l)
+}
+
+func (m *mockListStore) DeleteList(ctx context.Context, id int) error {
+	return m.deleteFn(ctx, id)
+}
+
+func (m *mockListStore) ListTodos(ctx context.Context, listID int, offset int, limit int) ([]model.Todo, error) {
+	return m.todosFn(ctx, listID, offset, limit)
+}
+
+func (m *mockListStore) ListCollaborators(ctx context.Context, listID int) ([]model.Collaborator, error) {
+	return m.collaboratorsFn(ctx, listID)
+}
+
+func (m *mockListStore) GrantList(ctx context.Context, listID int, c model.Collaborator) error {
+	return m.grantFn(ctx, listID, c)
+}
+
+func (m *mockListStore) RevokeList(ctx context.Context, listID int, principal string) error {
+	return m.revokeFn(ctx, listID, principal)
+}
+
+func TestPostList(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal string
+		body      string
+		err       error
+		want      int
+	}{
+		{name: "post_list", principal: "alice", body: `{"name":"groceries"}`, want: http.StatusCreated},
+		{name: "post_list_anonymous", body: `{"name":"groceries"}`, want: http.StatusUnauthorized},
+		{name: "post_list_empty_name", principal: "alice", body: `{"name":" "}`, want: http.StatusUnprocessableEntity},
+		{name: "post_list_error", principal: "alice", body: `{"name":"groceries"}`, err: errors.New("test error"), want: http.StatusInternalServerError},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := &mockListStore{
+				createFn: func(ctx context.Context, l model.List) (model.List, error) {
+					l.Id = 1
+					return l, tc.err
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/list", bytes.NewBufferString(tc.body))
+			r.Header.Set("Content-Type", "application/json")
+			if tc.principal != "" {
+				r.Header.Set(auth.PrincipalIDHeader, tc.principal)
+			}
+			NewMux(&mockTodoStore{}, WithLists(ls), WithEasyAuth()).ServeHTTP(w, r)
+			if got := w.Result().StatusCode; got != tc.want {
+				t.Fatalf("Want status code %d, got %d", tc.want, got)
+			}
+			if tc.want != http.StatusCreated {
+				return
+			}
+			if got := w.Header().Get("Location"); got != "/list/1" {
+				t.Errorf("Want Location %q, got %q", "/list/1", got)
+			}
+			var got model.List
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("Fatal error decoding response: %v", err)
+			}
+			if got.Name != "groceries" {
+				t.Errorf("Want name %q, got %q", "groceries", got.Name)
+			}
+		})
+	}
+}
+
+func TestListTodos(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		result []model.Todo
+		err    error
+		want   int
+	}{
+		{name: "list_todos", target: "/list/1/todo", result: []model.Todo{{Id: 1, Description: "milk"}}, want: http.StatusOK},
+		{name: "list_todos_not_found", target: "/list/1/todo", err: model.ErrEmptyResultSet, want: http.StatusNotFound},
+		{name: "list_todos_invalid_id", target: "/list/x/todo", want: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := &mockListStore{
+				todosFn: func(ctx context.Context, listID int, offset int, limit int) ([]model.Todo, error) {
+					if listID != 1 {
+						t.Errorf("Want list ID 1, got %d", listID)
+					}
+					return tc.result, tc.err
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+			NewMux(&mockTodoStore{}, WithLists(ls)).ServeHTTP(w, r)
+			if got := w.Result().StatusCode; got != tc.want {
+				t.Fatalf("Want status code %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDeleteList(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "delete_list", want: http.StatusNoContent},
+		{name: "delete_list_forbidden", err: model.ErrForbidden, want: http.StatusForbidden},
+		{name: "delete_list_not_found", err: model.ErrEmptyResultSet, want: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := &mockListStore{
+				deleteFn: func(ctx context.Context, id int) error {
+					return tc.err
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodDelete, "/list/1", nil)
+			NewMux(&mockTodoStore{}, WithLists(ls)).ServeHTTP(w, r)
+			if got := w.Result().StatusCode; got != tc.want {
+				t.Fatalf("Want status code %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGrantList(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal string
+		err       error
+		want      int
+	}{
+		{name: "grant_list", principal: "alice", want: http.StatusOK},
+		{name: "grant_list_anonymous", want: http.StatusUnauthorized},
+		{name: "grant_list_not_owner", principal: "alice", err: model.ErrForbidden, want: http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := &mockListStore{
+				grantFn: func(ctx context.Context, listID int, c model.Collaborator) error {
+					return tc.err
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, "/list/1/collaborators/bob", bytes.NewBufferString(`{"role":"editor"}`))
+			r.Header.Set("Content-Type", "application/json")
+			if tc.principal != "" {
+				r.Header.Set(auth.PrincipalIDHeader, tc.principal)
+			}
+			NewMux(&mockTodoStore{}, WithLists(ls), WithEasyAuth()).ServeHTTP(w, r)
+			if got := w.Result().StatusCode; got != tc.want {
+				t.Fatalf("Want status code %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+func TestMetrics(t *testing.T) {
+	ts := &mockTodoStore{
+		findFn: func(ctx context.Context, id int) (model.Todo, error) {
+			if id == 404 {
+				return model.Todo{}, model.ErrEmptyResultSet
+			}
+			return model.Todo{Id: int64(id)}, nil
+		},
+	}
+	reg := prometheus.NewRegistry()
+	mux := NewMux(ts, WithMetrics(reg), WithPublicMetrics())
+
+	for _, path := range []string{"/todo/1", "/todo/2", "/todo/404", "/nope/1", "/nope/2"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/todo/1", nil))
+
+	tests := []struct {
+		name   string
+		labels []string
+		want   float64
+	}{
+		{"route pattern", []string{"GET", "/todo/{id:[0-9]+}", "200"}, 2},
+		{"error status", []string{"GET", "/todo/{id:[0-9]+}", "404"}, 1},
+		{"unmatched", []string{"GET", "unmatched", "404"}, 2},
+		{"unknown method", []string{"OTHER", "unmatched", "405"}, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := requestCount(t, reg, tc.labels...); got != tc.want {
+				t.Errorf("Want %v requests for %v, got %v", tc.want, tc.labels, got)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Want status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "todo_http_request_duration_seconds_bucket") {
+		t.Errorf("Want latency histogram in /metrics, got %s", w.Body.String())
+	}
+	if n := testutil.CollectAndCount(reg, "todo_http_requests_total"); n != 5 {
+		t.Errorf("Want 5 request series, got %d", n)
+	}
+
+	w = httptest.NewRecorder()
+	NewMux(ts, WithMetrics(prometheus.NewRegistry())).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Want status code %d without public metrics, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+// requestCount returns the value of todo_http_requests_total for the given method, route and code.
+func requestCount(t *testing.T, reg *prometheus.Registry, labels ...string) float64 {
+	t.Helper()
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() != "todo_http_requests_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			got := make(map[string]string)
+			for _, lp := range m.GetLabel() {
+				got[lp.GetName()] = lp.GetValue()
+			}
+			if got["method"] == labels[0] && got["route"] == labels[1] && got["code"] == labels[2] {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
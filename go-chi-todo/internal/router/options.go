@@ -0,0 +1,172 @@
+package router
+
+import (
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/breaker"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/cors"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/drain"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/health"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/ratelimit"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type Option func(*options)
+
+type options struct {
+	trustedProxyHops  int
+	rateLimitBackend  ratelimit.Backend
+	readLimit         ratelimit.Limit
+	writeLimit        ratelimit.Limit
+	idempotencyStore  model.IdempotencyStore
+	idempotencyTTL    time.Duration
+	cors              *cors.Policy
+	collaboratorStore model.CollaboratorStore
+	breaker           *breaker.Store
+	dbTimeout         time.Duration
+	routeTimeouts     map[string]time.Duration
+	metrics           *prometheus.Registry
+	publicMetrics     bool
+	health            *health.Registry
+	drainer           *drain.Drainer
+	accessLog         bool
+	logSampleRate     float64
+	logSlow           time.Duration
+	trustEasyAuth     bool
+	anonymous         bool
+	listStore         model.ListStore
+	apiKeys           []string
+}
+
+// WithTrustedProxyHops sets the number of reverse proxies in front of the app that append
+// to X-Forwarded-For. Container Apps ingress adds exactly one hop. Without it,
+// X-Forwarded-For is ignored.
+func WithTrustedProxyHops(n int) Option {
+	return func(o *options) {
+		o.trustedProxyHops = n
+	}
+}
+
+// WithRateLimit enables per-client rate limiting with separate limits for reads
+// (GET, HEAD) and writes. A zero Limit disables limiting for that route class.
+func WithRateLimit(b ratelimit.Backend, reads ratelimit.Limit, writes ratelimit.Limit) Option {
+	return func(o *options) {
+		o.rateLimitBackend = b
+		o.readLimit = reads
+		o.writeLimit = writes
+	}
+}
+
+// WithIdempotency enables Idempotency-Key support for POST requests. Responses are
+// kept for ttl and replayed for retries of the same request.
+func WithIdempotency(is model.IdempotencyStore, ttl time.Duration) Option {
+	return func(o *options) {
+		o.idempotencyStore = is
+		o.idempotencyTTL = ttl
+	}
+}
+
+// WithCORS enables cross-origin requests from browsers. Preflight requests are answered
+// before any other middleware runs.
+func WithCORS(p *cors.Policy) Option {
+	return func(o *options) {
+		o.cors = p
+	}
+}
+
+// WithCollaborators enables the endpoints to share todos with other principals.
+func WithCollaborators(cs model.CollaboratorStore) Option {
+	return func(o *options) {
+		o.collaboratorStore = cs
+	}
+}
+
+// WithBreaker reports the state of the circuit breakers of b in the readiness check. b should
+// be the store passed to NewMux.
+func WithBreaker(b *breaker.Store) Option {
+	return func(o *options) {
+		o.breaker = b
+	}
+}
+
+// WithDBTimeouts limits how long requests wait for the database. routes overrides timeout for
+// routes identified as "<method> <pattern>" without parameter patterns, e.g. "GET /todo/{id}".
+func WithDBTimeouts(timeout time.Duration, routes map[string]time.Duration) Option {
+	return func(o *options) {
+		o.dbTimeout = timeout
+		o.routeTimeouts = routes
+	}
+}
+
+// WithMetrics records HTTP request metrics in reg.
+func WithMetrics(reg *prometheus.Registry) Option {
+	return func(o *options) {
+		o.metrics = reg
+	}
+}
+
+// WithPublicMetrics serves all metrics in the registry passed to WithMetrics at /metrics.
+// Metrics reveal details about the deployment, so prefer the admin listener.
+func WithPublicMetrics() Option {
+	return func(o *options) {
+		o.publicMetrics = true
+	}
+}
+
+// WithHealth serves the checks in h at /healthz/ready and /healthz/startup. Without it, only
+// the store's Ping is checked.
+func WithHealth(h *health.Registry) Option {
+	return func(o *options) {
+		o.health = h
+	}
+}
+
+// WithDrain tracks in-flight requests with d and fails readiness while d is draining.
+func WithDrain(d *drain.Drainer) Option {
+	return func(o *options) {
+		o.drainer = d
+	}
+}
+
+// WithAccessLog logs every failed request and every request slower than slow, and samples
+// other requests with sampleRate between 0 and 1.
+func WithAccessLog(sampleRate float64, slow time.Duration) Option {
+	return func(o *options) {
+		o.accessLog = true
+		o.logSampleRate = sampleRate
+		o.logSlow = slow
+	}
+}
+
+// WithEasyAuth trusts the principal headers set by Container Apps built-in authentication.
+// Only use it if Easy Auth is enabled, since the platform doesn't strip the headers otherwise.
+func WithEasyAuth() Option {
+	return func(o *options) {
+		o.trustEasyAuth = true
+	}
+}
+
+// WithAnonymous lets callers without a verified principal create todos when WithEasyAuth is
+// set. These todos are public, i.e. visible to and editable by everyone. Without WithEasyAuth,
+// there are no principals, so everyone can create todos anyway.
+func WithAnonymous() Option {
+	return func(o *options) {
+		o.anonymous = true
+	}
+}
+
+// WithLists enables the endpoints to manage lists of todos and share them with other principals.
+func WithLists(ls model.ListStore) Option {
+	return func(o *options) {
+		o.listStore = ls
+	}
+}
+
+// WithAPIKeys rate limits requests that carry one of keys in X-API-Key per key instead of per
+// client IP address.
+func WithAPIKeys(keys []string) Option {
+	return func(o *options) {
+		o.apiKeys = keys
+	}
+}
@@ -0,0 +1,204 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+// Problem types let clients tell apart errors that share a status code.
+const (
+	problemTypeMalformedJSON   = "urn:todo:problem:malformed-json"
+	problemTypeInvalidJSONType = "urn:todo:problem:invalid-json-type"
+	problemTypeUnknownField    = "urn:todo:problem:unknown-field"
+	problemTypeEmptyBody       = "urn:todo:problem:empty-body"
+	problemTypeBodyTooLarge    = "urn:todo:problem:body-too-large"
+	problemTypeValidation      = "urn:todo:problem:validation"
+	problemTypeIdempotency     = "urn:todo:problem:idempotency-key-reused"
+	problemTypeInFlight        = "urn:todo:problem:request-in-flight"
+	problemTypeRateLimited     = "urn:todo:problem:rate-limited"
+)
+
+// statusClientClosedRequest is the nginx convention for requests the client gave up on. The
+// client never sees it, but logs and metrics don't count it as a server error.
+const statusClientClosedRequest = 499
+
+// problem is an RFC 7807 problem details object. It implements error so handlers can
+// return specific problems through the same path as any other error.
+type problem struct {
+	Type          string             `json:"type"`
+	Title         string             `json:"title"`
+	Status        int                `json:"status"`
+	Detail        string             `json:"detail,omitempty"`
+	Instance      string             `json:"instance,omitempty"`
+	CorrelationID string             `json:"correlationId,omitempty"`
+	Errors        []model.FieldError `json:"errors,omitempty"`
+	headers       []header
+	cause         error
+}
+
+func (p *problem) Error() string {
+	if p.cause != nil {
+		return p.cause.Error()
+	}
+	if p.Detail != "" {
+		return p.Detail
+	}
+	return p.Title
+}
+
+func (p *problem) Unwrap() error {
+	return p.cause
+}
+
+func newProblem(status int, detail string) *problem {
+	return &problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// bindError marks errors that occurred while decoding a request body.
+type bindError struct {
+	err error
+}
+
+func (e *bindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *bindError) Unwrap() error {
+	return e.err
+}
+
+// problemFor maps any error returned by bind, validation or a store to a problem.
+func problemFor(err error) *problem {
+	var p *problem
+	if errors.As(err, &p) {
+		return p
+	}
+	var verr *model.ValidationError
+	if errors.As(err, &verr) {
+		p := newProblem(http.StatusUnprocessableEntity, "The request contains invalid fields.")
+		p.Type = problemTypeValidation
+		p.Title = "Validation failed"
+		p.Errors = verr.Errors
+		return p
+	}
+	var berr *bindError
+	if errors.As(err, &berr) {
+		return bindProblem(berr.err)
+	}
+	var uerr *model.UnavailableError
+	if errors.As(err, &uerr) {
+		p := newProblem(http.StatusServiceUnavailable, "The service is temporarily unavailable: "+uerr.Reason+".")
+		if uerr.RetryAfter > 0 {
+			p.headers = []header{{name: "Retry-After", val: strconv.Itoa(ceilSeconds(uerr.RetryAfter))}}
+		}
+		p.cause = err
+		return p
+	}
+	switch {
+	case errors.Is(err, model.ErrEmptyResultSet):
+		return newProblem(http.StatusNotFound, "The requested item does not exist.")
+	case errors.Is(err, model.ErrUnauthenticated):
+		return newProblem(http.StatusUnauthorized, "You must sign in to perform this operation.")
+	case errors.Is(err, model.ErrForbidden):
+		return newProblem(http.StatusForbidden, "You are not allowed to modify this item.")
+	case errors.Is(err, context.Canceled):
+		p := newProblem(statusClientClosedRequest, "The request was canceled by the client.")
+		p.Title = "Client Closed Request"
+		p.cause = err
+		return p
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, model.ErrTimeout):
+		p := newProblem(http.StatusGatewayTimeout, "The database did not respond in time, please retry later.")
+		p.cause = err
+		return p
+	}
+	p = newProblem(http.StatusInternalServerError, "")
+	p.cause = err
+	return p
+}
+
+func bindProblem(err error) *problem {
+	p := newProblem(http.StatusBadRequest, "")
+	p.cause = err
+	var (
+		mbe *http.MaxBytesError
+		se  *json.SyntaxError
+		te  *json.UnmarshalTypeError
+		ufe *unknownFieldError
+	)
+	if errors.As(err, &mbe) {
+		p.Status, p.Title, p.Type = http.StatusRequestEntityTooLarge, http.StatusText(http.StatusRequestEntityTooLarge), problemTypeBodyTooLarge
+		p.Detail = fmt.Sprintf("The request body must not be larger than %d bytes.", mbe.Limit)
+		return p
+	}
+	if errors.As(err, &se) {
+		p.Type, p.Title = problemTypeMalformedJSON, "Malformed JSON"
+		p.Detail = fmt.Sprintf("The request body contains malformed JSON at offset %d.", se.Offset)
+		return p
+	}
+	if errors.As(err, &te) {
+		p.Type, p.Title = problemTypeInvalidJSONType, "Invalid JSON type"
+		p.Detail = fmt.Sprintf("Field %q must be of type %s.", te.Field, te.Type)
+		return p
+	}
+	if errors.As(err, &ufe) {
+		p.Type, p.Title = problemTypeUnknownField, "Unknown field"
+		p.Detail = fmt.Sprintf("The request body contains unknown field %s.", ufe.field)
+		return p
+	}
+	switch {
+	case errors.Is(err, io.EOF):
+		p.Type, p.Title, p.Detail = problemTypeEmptyBody, "Empty body", "The request body must not be empty."
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		p.Type, p.Title, p.Detail = problemTypeMalformedJSON, "Malformed JSON", "The request body contains incomplete JSON."
+	default:
+		p.Type, p.Title, p.Detail = problemTypeMalformedJSON, "Malformed JSON", err.Error()
+	}
+	return p
+}
+
+// writeError is the single place where errors are turned into responses.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	p := *problemFor(err)
+	p.Instance = r.URL.Path
+	p.CorrelationID, _ = log.RequestIDFromContext(r.Context())
+
+	attrs := []any{slog.Int("status", p.Status), slog.String("path", p.Instance)}
+	switch {
+	case p.Status >= http.StatusInternalServerError:
+		slog.ErrorContext(r.Context(), "handling request", append(attrs, log.ErrorKey, err)...)
+	case p.Status == statusClientClosedRequest:
+		slog.DebugContext(r.Context(), "request canceled", attrs...)
+	default:
+		slog.InfoContext(r.Context(), "rejecting request", append(attrs, slog.String("reason", p.Error()))...)
+	}
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "encoding problem", log.ErrorKey, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	for _, h := range p.headers {
+		w.Header().Set(h.name, h.val)
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(b)
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, newProblem(http.StatusNotFound, "The requested resource does not exist."))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, newProblem(http.StatusMethodNotAllowed, fmt.Sprintf("Method %s is not supported for this resource.", r.Method)))
+}
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+func TestProblemResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		storeErr    error
+		want        int
+		wantType    string
+	}{
+		{
+			name:        "syntax_error",
+			method:      http.MethodPost,
+			target:      "/todo",
+			contentType: "application/json",
+			body:        `{"description": "test",}`,
+			want:        http.StatusBadRequest,
+			wantType:    problemTypeMalformedJSON,
+		},
+		{
+			name:        "incomplete_json",
+			method:      http.MethodPost,
+			target:      "/todo",
+			contentType: "application/json",
+			body:        `{"description": "test"`,
+			want:        http.StatusBadRequest,
+			wantType:    problemTypeMalformedJSON,
+		},
+		{
+			name:        "unknown_field",
+			method:      http.MethodPost,
+			target:      "/todo",
+			contentType: "application/json",
+			body:        `{"description": "test", "title": "test"}`,
+			want:        http.StatusBadRequest,
+			wantType:    problemTypeUnknownField,
+		},
+		{
+			name:        "invalid_type",
+			method:      http.MethodPut,
+			target:      "/todo/1",
+			contentType: "application/json",
+			body:        `{"description": "test", "done": "yes"}`,
+			want:        http.StatusBadRequest,
+			wantType:    problemTypeInvalidJSONType,
+		},
+		{
+			name:        "oversized_body",
+			method:      http.MethodPost,
+			target:      "/todo",
+			contentType: "application/json",
+			body:        `{"description": "` + strings.Repeat("a", maxJSONBodyBytes) + `"}`,
+			want:        http.StatusRequestEntityTooLarge,
+			wantType:    problemTypeBodyTooLarge,
+		},
+		{
+			name:        "validation",
+			method:      http.MethodPost,
+			target:      "/todo",
+			contentType: "application/json",
+			body:        `{"description": ""}`,
+			want:        http.StatusUnprocessableEntity,
+			wantType:    problemTypeValidation,
+		},
+		{
+			name:        "unsupported_media_type",
+			method:      http.MethodPost,
+			target:      "/todo",
+			contentType: "text/plain",
+			body:        `{"description": "test"}`,
+			want:        http.StatusUnsupportedMediaType,
+			wantType:    "about:blank",
+		},
+		{
+			name:     "not_found_in_store",
+			method:   http.MethodGet,
+			target:   "/todo/1",
+			storeErr: model.ErrEmptyResultSet,
+			want:     http.StatusNotFound,
+			wantType: "about:blank",
+		},
+		{
+			name:     "store_error",
+			method:   http.MethodGet,
+			target:   "/todo/1",
+			storeErr: errors.New("connection string contains a secret"),
+			want:     http.StatusInternalServerError,
+			wantType: "about:blank",
+		},
+		{
+			name:     "client_canceled",
+			method:   http.MethodGet,
+			target:   "/todo/1",
+			storeErr: fmt.Errorf("finding todo: %w", context.Canceled),
+			want:     statusClientClosedRequest,
+			wantType: "about:blank",
+		},
+		{
+			name:     "unknown_route",
+			method:   http.MethodGet,
+			target:   "/books",
+			want:     http.StatusNotFound,
+			wantType: "about:blank",
+		},
+		{
+			name:     "method_not_allowed",
+			method:   http.MethodPatch,
+			target:   "/todo/1",
+			want:     http.StatusMethodNotAllowed,
+			wantType: "about:blank",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := &mockTodoStore{
+				findFn: func(ctx context.Context, id int) (model.Todo, error) {
+					return model.Todo{}, tc.storeErr
+				},
+				createFn: func(ctx context.Context, item model.Todo) (model.Todo, error) {
+					return item, tc.storeErr
+				},
+				updateFn: func(ctx context.Context, item model.Todo) (model.Todo, error) {
+					return item, tc.storeErr
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tc.method, tc.target, bytes.NewBufferString(tc.body))
+			if tc.contentType != "" {
+				r.Header.Set("Content-Type", tc.contentType)
+			}
+			r.Header.Set(middleware.RequestIDHeader, "test-correlation-id")
+			NewMux(ts).ServeHTTP(w, r)
+
+			res := w.Result()
+			if res.StatusCode != tc.want {
+				t.Fatalf("Want status code %d, got %d", tc.want, res.StatusCode)
+			}
+			if ct := res.Header.Get("Content-Type"); ct != "application/problem+json" {
+				t.Errorf("Want application/problem+json, got %q", ct)
+			}
+			var p problem
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Fatalf("Fatal error decoding problem: %v", err)
+			}
+			if p.Status != tc.want {
+				t.Errorf("Want problem status %d, got %d", tc.want, p.Status)
+			}
+			if p.Type != tc.wantType {
+				t.Errorf("Want problem type %q, got %q", tc.wantType, p.Type)
+			}
+			if p.Title == "" {
+				t.Error("Want problem title to be set")
+			}
+			if p.Instance != tc.target {
+				t.Errorf("Want instance %q, got %q", tc.target, p.Instance)
+			}
+			if p.CorrelationID != "test-correlation-id" {
+				t.Errorf("Want correlation id %q, got %q", "test-correlation-id", p.CorrelationID)
+			}
+			if strings.Contains(p.Detail, "secret") {
+				t.Errorf("Want internal error details to be hidden, got %q", p.Detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/ratelimit"
+)
+
+func rateLimit(b ratelimit.Backend, reads ratelimit.Limit, writes ratelimit.Limit, hops int, keys apiKeys) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			class, l := "write", writes
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				class, l = "read", reads
+			}
+			if !l.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := b.Allow(r.Context(), class+":"+clientKey(r, hops, keys), l)
+			if err != nil {
+				// Fail open, an unavailable backend must not take down the API.
+				slog.ErrorContext(r.Context(), "checking rate limit", log.ErrorKey, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			if !res.Allowed {
+				p := newProblem(http.StatusTooManyRequests, "Too many requests, please retry later.")
+				p.Type = problemTypeRateLimited
+				p.headers = []header{{name: "Retry-After", val: strconv.Itoa(ceilSeconds(res.RetryAfter))}}
+				writeError(w, r, p)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
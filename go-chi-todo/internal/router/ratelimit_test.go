@@ -0,0 +1,185 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/ratelimit"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		hops       int
+		want       string
+	}{
+		{
+			name:       "no_proxy",
+			remoteAddr: "192.0.2.1:1234",
+			hops:       0,
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "xff_ignored_without_trusted_hops",
+			remoteAddr: "192.0.2.1:1234",
+			xff:        []string{"203.0.113.7"},
+			hops:       0,
+			want:       "192.0.2.1",
+		},
+		{
+			name:       "single_hop",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"203.0.113.7"},
+			hops:       1,
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "single_hop_spoofed",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"198.51.100.1, 203.0.113.7"},
+			hops:       1,
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "two_hops_multiple_headers",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"198.51.100.1, 203.0.113.7", "10.0.0.2"},
+			hops:       2,
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "more_hops_than_entries",
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"203.0.113.7"},
+			hops:       3,
+			want:       "203.0.113.7",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/todo", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r, tc.hops); got != tc.want {
+				t.Errorf("Want client IP %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	keys := newAPIKeys([]string{"secret"})
+	r := httptest.NewRequest(http.MethodGet, "/todo", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	if got, want := clientKey(r, 0, keys), "ip:192.0.2.1"; got != want {
+		t.Errorf("Want key %q, got %q", want, got)
+	}
+	r.Header.Set(apiKeyHeader, "unknown")
+	if got, want := clientKey(r, 0, keys), "ip:192.0.2.1"; got != want {
+		t.Errorf("Want unknown API key to be ignored, got %q", got)
+	}
+	r.Header.Set(apiKeyHeader, "secret")
+	if got := clientKey(r, 0, keys); got[:7] != "apikey:" || got == "apikey:secret" {
+		t.Errorf("Want hashed API key, got %q", got)
+	}
+	r = r.WithContext(auth.NewContext(r.Context(), auth.Principal{ID: "alice"}))
+	if got, want := clientKey(r, 0, keys), "principal:alice"; got != want {
+		t.Errorf("Want key %q, got %q", want, got)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name  string
+		trust bool
+		want  bool
+	}{
+		{"untrusted", false, false},
+		{"trusted", true, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ok bool
+			var header string
+			h := authenticate(tc.trust)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, ok = auth.FromContext(r.Context())
+				header = r.Header.Get(auth.PrincipalIDHeader)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/todo", nil)
+			r.Header.Set(auth.PrincipalIDHeader, "alice")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			if ok != tc.want {
+				t.Errorf("Want principal %v, got %v", tc.want, ok)
+			}
+			if (header != "") != tc.want {
+				t.Errorf("Want principal header kept %v, got %q", tc.want, header)
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	ts := &mockTodoStore{
+		listFn: func(ctx context.Context, offset int, limit int) ([]model.Todo, error) {
+			return []model.Todo{}, nil
+		},
+		deleteFn: func(ctx context.Context, id int) error {
+			return nil
+		},
+	}
+	reads := ratelimit.Limit{Rate: 1, Burst: 2}
+	writes := ratelimit.Limit{Rate: 1, Burst: 1}
+	mux := NewMux(ts, WithRateLimit(ratelimit.NewMemory(time.Minute), reads, writes), WithEasyAuth())
+
+	do := func(method string, target string, principal string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, nil)
+		if principal != "" {
+			r.Header.Set(auth.PrincipalIDHeader, principal)
+		}
+		mux.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	for i := range 2 {
+		if res := do(http.MethodGet, "/todo", "alice"); res.StatusCode != http.StatusOK {
+			t.Fatalf("Want status code %d for read %d, got %d", http.StatusOK, i, res.StatusCode)
+		}
+	}
+	res := do(http.MethodGet, "/todo", "alice")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Want status code %d, got %d", http.StatusTooManyRequests, res.StatusCode)
+	}
+	for _, h := range []string{"Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"} {
+		if res.Header.Get(h) == "" {
+			t.Errorf("Want header %q to be set", h)
+		}
+	}
+	if got := res.Header.Get("RateLimit-Limit"); got != "2" {
+		t.Errorf("Want RateLimit-Limit 2, got %q", got)
+	}
+
+	// Writes use a separate bucket, other principals are not affected.
+	if res := do(http.MethodDelete, "/todo/1", "alice"); res.StatusCode != http.StatusNoContent {
+		t.Errorf("Want status code %d for write, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	if res := do(http.MethodGet, "/todo", "bob"); res.StatusCode != http.StatusOK {
+		t.Errorf("Want status code %d for other principal, got %d", http.StatusOK, res.StatusCode)
+	}
+	// Health probes are never limited.
+	ts.pingFn = func(ctx context.Context) error { return nil }
+	for range 5 {
+		if res := do(http.MethodGet, "/healthz/ready", ""); res.StatusCode != http.StatusOK {
+			t.Fatalf("Want status code %d for readiness, got %d", http.StatusOK, res.StatusCode)
+		}
+	}
+}
@@ -1,18 +1,18 @@
 package router
 
 import (
-	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
-	"strings"
-
-	"log/slog"
+	"sync/atomic"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/health"
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/log"
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type header struct {
@@ -26,70 +26,117 @@ const (
 	maxLimit         = 100
 )
 
-func NewMux(ts model.TodoStore) *chi.Mux {
+func NewMux(ts model.TodoStore, opts ...Option) *chi.Mux {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.health == nil {
+		o.health = health.New()
+		o.health.Register("database", ts.Ping)
+	}
+	if o.breaker != nil {
+		// Breaker state is cheap to read and changes quickly, so it isn't cached.
+		o.health.Register("breaker", o.breaker.Check, health.WithCacheTTL(0))
+	}
+	if o.drainer != nil {
+		o.health.Register("drain", o.drainer.Check, health.WithCacheTTL(0))
+	}
+
 	r := chi.NewRouter()
+	r.NotFound(notFound)
+	r.MethodNotAllowed(methodNotAllowed)
+	r.Use(traced)
+	if o.drainer != nil {
+		r.Use(o.drainer.Handler)
+	}
+	if o.metrics != nil {
+		r.Use(newHTTPMetrics(o.metrics).handler)
+	}
+	r.Use(log.RequestID)
+	if o.accessLog {
+		r.Use(accessLog(o.logSampleRate, o.logSlow, o.trustedProxyHops))
+	}
+	if o.cors != nil {
+		r.Use(o.cors.Handler)
+	}
 	r.Use(
 		middleware.StripSlashes,
 		middleware.GetHead,
 		middleware.Heartbeat("/healthz/live"),
-		middleware.AllowContentType("application/json"))
-	r.Get("/healthz/ready", readyHandler(ts))
-	r.Get("/todo", getManyHandler(ts))
-	r.Post("/todo", postHandler(ts))
-	r.Get("/todo/{id:[0-9]+}", getHandler(ts))
-	r.Put("/todo/{id:[0-9]+}", putHandler(ts))
-	r.Delete("/todo/{id:[0-9]+}", deleteHandler(ts))
+		allowContentType("application/json"),
+		authenticate(o.trustEasyAuth),
+		clientSubject,
+		readYourWrites)
+	r.Get("/healthz/ready", readyHandler(o.health))
+	r.Get("/healthz/startup", startupHandler(o.health))
+	if o.metrics != nil && o.publicMetrics {
+		r.Method(http.MethodGet, "/metrics", promhttp.HandlerFor(o.metrics, promhttp.HandlerOpts{}))
+	}
+	r.Group(func(r chi.Router) {
+		if o.rateLimitBackend != nil {
+			r.Use(rateLimit(o.rateLimitBackend, o.readLimit, o.writeLimit, o.trustedProxyHops, newAPIKeys(o.apiKeys)))
+		}
+		if o.dbTimeout > 0 || len(o.routeTimeouts) > 0 {
+			r.Use(deadline(o.dbTimeout, o.routeTimeouts))
+		}
+		r.Get("/todo", getManyHandler(ts))
+		create := r.With()
+		if o.trustEasyAuth && !o.anonymous {
+			create = create.With(requirePrincipal)
+		}
+		if o.idempotencyStore != nil {
+			create = create.With(idempotent(o.idempotencyStore, o.idempotencyTTL))
+		}
+		create.Post("/todo", postHandler(ts))
+		r.Get("/todo/{id:[0-9]+}", getHandler(ts))
+		r.Put("/todo/{id:[0-9]+}", putHandler(ts))
+		r.Delete("/todo/{id:[0-9]+}", deleteHandler(ts))
+		if o.collaboratorStore != nil {
+			r.Get("/todo/{id:[0-9]+}/collaborators", collaboratorsHandler(o.collaboratorStore))
+			r.With(requirePrincipal).Put("/todo/{id:[0-9]+}/collaborators/{principal}", grantHandler(o.collaboratorStore))
+			r.With(requirePrincipal).Delete("/todo/{id:[0-9]+}/collaborators/{principal}", revokeHandler(o.collaboratorStore))
+		}
+		if o.listStore != nil {
+			cs := listCollaborators{o.listStore}
+			r.Get("/list", getListsHandler(o.listStore))
+			r.With(requirePrincipal).Post("/list", postListHandler(o.listStore))
+			r.Get("/list/{id:[0-9]+}", getListHandler(o.listStore))
+			r.Delete("/list/{id:[0-9]+}", deleteListHandler(o.listStore))
+			r.Get("/list/{id:[0-9]+}/todo", getListTodosHandler(o.listStore))
+			r.Get("/list/{id:[0-9]+}/collaborators", collaboratorsHandler(cs))
+			r.With(requirePrincipal).Put("/list/{id:[0-9]+}/collaborators/{principal}", grantHandler(cs))
+			r.With(requirePrincipal).Delete("/list/{id:[0-9]+}/collaborators/{principal}", revokeHandler(cs))
+		}
+	})
 	return r
 }
 
 func getManyHandler(ts model.TodoStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		p := r.URL.Query().Get("offset")
-		offset, err := strconv.Atoi(p)
-		if err != nil {
-			offset = 0
-		}
-		if offset < 0 {
-			offset = 0
-		}
-		p = r.URL.Query().Get("limit")
-		limit, err := strconv.Atoi(p)
-		if err != nil || limit < 1 {
-			limit = defaultLimit
-		}
-		if limit > maxLimit {
-			limit = maxLimit
-		}
+		offset, limit := pageParams(r)
 		items, err := ts.List(r.Context(), offset, limit)
 		if err != nil {
-			slog.Error("reading from store", log.ErrorKey, err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeError(w, r, fmt.Errorf("reading from store: %w", err))
 			return
 		}
-		respond(w, items, http.StatusOK)
+		respond(w, r, items, http.StatusOK)
 	}
 }
 
 func getHandler(ts model.TodoStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		p := chi.URLParam(r, "id")
-		id, err := strconv.Atoi(p)
+		id, err := idParam(r)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 		item, err := ts.Find(r.Context(), id)
 		if err != nil {
-			if !errors.Is(err, model.ErrEmptyResultSet) {
-				slog.Error("reading from store", log.ErrorKey, err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-			slog.Info("item not found", slog.Int("id", id))
-			http.NotFound(w, r)
+			writeError(w, r, fmt.Errorf("reading from store: %w", err))
 			return
 		}
-		respond(w, item, http.StatusOK)
+		respond(w, r, item, http.StatusOK)
 	}
 }
 
@@ -99,23 +146,21 @@ func postHandler(ts model.TodoStore) http.HandlerFunc {
 		r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
 		var item model.Todo
 		if err := bind(r, &item); err != nil {
-			slog.Error("binding request body", log.ErrorKey, err)
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
-
 		}
-		if strings.TrimSpace(item.Description) == "" {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		item.Normalize()
+		if err := item.Validate(); err != nil {
+			writeError(w, r, err)
 			return
 		}
 		item, err := ts.Create(r.Context(), item)
 		if err != nil {
-			slog.Error("creating new todo item to store", log.ErrorKey, err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			writeError(w, r, fmt.Errorf("creating new todo item in store: %w", err))
 			return
 		}
 		loc := fmt.Sprintf("%s/%d", r.URL.String(), item.Id)
-		respond(w, item, http.StatusCreated, header{name: "Location", val: loc})
+		respond(w, r, item, http.StatusCreated, header{name: "Location", val: loc})
 	}
 }
 
@@ -125,67 +170,105 @@ func putHandler(ts model.TodoStore) http.HandlerFunc {
 		r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
 		var item model.Todo
 		if err := bind(r, &item); err != nil {
-			slog.Error("binding request body", log.ErrorKey, err)
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
-
 		}
-		p := chi.URLParam(r, "id")
-		id, err := strconv.Atoi(p)
+		id, err := idParam(r)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
-		if strings.TrimSpace(item.Description) == "" {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		item.Normalize()
+		if err := item.Validate(); err != nil {
+			writeError(w, r, err)
 			return
 		}
 		item.Id = int64(id)
 		item, err = ts.Update(r.Context(), item)
 		if err != nil {
-			if !errors.Is(err, model.ErrEmptyResultSet) {
-				slog.Error("updating todo item in store", log.ErrorKey, err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-			slog.Info("item not found", slog.Int("id", id))
-			http.NotFound(w, r)
+			writeError(w, r, fmt.Errorf("updating todo item in store: %w", err))
 			return
 		}
-		respond(w, item, http.StatusOK)
+		respond(w, r, item, http.StatusOK)
 	}
 }
 
 func deleteHandler(ts model.TodoStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		p := chi.URLParam(r, "id")
-		id, err := strconv.Atoi(p)
+		id, err := idParam(r)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			writeError(w, r, err)
 			return
 		}
 		err = ts.Delete(r.Context(), id)
 		if err != nil {
-			if !errors.Is(err, model.ErrEmptyResultSet) {
-				slog.Error("deleting from store", log.ErrorKey, err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-				return
-			}
-			slog.Info("item not found", slog.Int("id", id))
-			http.NotFound(w, r)
+			writeError(w, r, fmt.Errorf("deleting from store: %w", err))
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func readyHandler(ts model.TodoStore) http.HandlerFunc {
+// healthStatus is the body of the public health endpoints. The results of the individual
+// checks are only logged and served by the admin listener, since they reveal internals.
+type healthStatus struct {
+	Status health.Status `json:"status"`
+}
+
+func readyHandler(h *health.Registry) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := ts.Ping(r.Context()); err != nil {
-			slog.Error("checking readiness", log.ErrorKey, err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		report := h.Run(r.Context())
+		respond(w, r, healthStatus{report.Status}, reportStatusCode(r, report))
+	}
+}
+
+// startupHandler runs the checks until they pass once. After that, the app counts as started
+// and readiness takes over.
+func startupHandler(h *health.Registry) http.HandlerFunc {
+	var started atomic.Bool
+	return func(w http.ResponseWriter, r *http.Request) {
+		if started.Load() {
+			respond(w, r, healthStatus{health.Pass}, http.StatusOK)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
+		report := h.Run(r.Context())
+		if report.Status != health.Fail {
+			started.Store(true)
+		}
+		respond(w, r, healthStatus{report.Status}, reportStatusCode(r, report))
+	}
+}
+
+func reportStatusCode(r *http.Request, report health.Report) int {
+	if report.Status != health.Fail {
+		return http.StatusOK
+	}
+	for name, res := range report.Checks {
+		if res.Status == health.Fail {
+			slog.WarnContext(r.Context(), "health check failed", slog.String("path", r.URL.Path), slog.String("check", name), slog.String("output", res.Output))
+		}
+	}
+	return http.StatusServiceUnavailable
+}
+
+// pageParams returns the offset and limit query parameters. Invalid values are replaced by
+// their defaults, and limit is capped at maxLimit.
+func pageParams(r *http.Request) (int, int) {
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultLimit
+	}
+	return offset, min(limit, maxLimit)
+}
+
+func idParam(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return 0, newProblem(http.StatusBadRequest, "The item id must be a number.")
 	}
+	return id, nil
 }
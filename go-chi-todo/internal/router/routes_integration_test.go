@@ -15,6 +15,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/envelope"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
 	pg "github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/postgres"
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/router"
 	"github.com/testcontainers/testcontainers-go"
@@ -302,6 +304,12 @@ func TestPostTodo(t *testing.T) {
 			contentType: "application/json",
 			want:        http.StatusBadRequest,
 		},
+		{
+			name:        "post_todo_details_too_long",
+			item:        []byte(`{"description": "New todo 5", "details": "` + strings.Repeat("x", 256) + `", "done":true}`),
+			contentType: "application/json",
+			want:        http.StatusUnprocessableEntity,
+		},
 	}
 
 	for _, tc := range tests {
@@ -522,3 +530,378 @@ func TestDeleteTodo(t *testing.T) {
 		})
 	}
 }
+
+func TestIdempotentPostTodo(t *testing.T) {
+	ctx := context.Background()
+	pgContainer, err := runPostgres(ctx, "postgres:16-alpine")
+	if err != nil {
+		t.Fatalf("failed to initialize Postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Errorf("failed to terminate Postgres container: %v", err)
+		}
+	})
+
+	connStr, err := pgContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	ts, err := pg.NewStore(ctx, connStr)
+	if err != nil {
+		t.Fatalf("failed to create TodoStore: %v", err)
+	}
+	t.Cleanup(func() {
+		ts.Close(ctx)
+	})
+
+	srv := httptest.NewServer(router.NewMux(ts, router.WithIdempotency(ts, time.Hour)))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	tests := []struct {
+		name string
+		key  string
+		item string
+		want int
+	}{
+		{
+			name: "post_todo_created",
+			key:  "key-1",
+			item: `{"description": "New todo", "details": "This is a test todo item", "done":false}`,
+			want: http.StatusCreated,
+		},
+		{
+			name: "post_todo_replayed",
+			key:  "key-1",
+			item: `{"description": "New todo", "details": "This is a test todo item", "done":false}`,
+			want: http.StatusCreated,
+		},
+		{
+			name: "post_todo_key_reused",
+			key:  "key-1",
+			item: `{"description": "Other todo", "details": "This is a test todo item", "done":false}`,
+			want: http.StatusConflict,
+		},
+	}
+
+	var locations []string
+	client := srv.Client()
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, srv.URL+"/todo", bytes.NewBufferString(tc.item))
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Idempotency-Key", tc.key)
+
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("failed to post todo: %v", err)
+			}
+			t.Cleanup(func() {
+				resp.Body.Close()
+			})
+
+			if resp.StatusCode != tc.want {
+				t.Errorf("want status code %d, got %d", tc.want, resp.StatusCode)
+			}
+			if resp.StatusCode == http.StatusCreated {
+				locations = append(locations, resp.Header.Get("Location"))
+			}
+		})
+	}
+
+	if len(locations) != 2 || locations[0] != locations[1] {
+		t.Errorf("want replayed request to return the same location, got %v", locations)
+	}
+}
+
+func TestShareTodo(t *testing.T) {
+	ctx := context.Background()
+	pgContainer, err := runPostgres(ctx, "postgres:16-alpine")
+	if err != nil {
+		t.Fatalf("failed to initialize Postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Errorf("failed to terminate Postgres container: %v", err)
+		}
+	})
+
+	connStr, err := pgContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	ts, err := pg.NewStore(ctx, connStr)
+	if err != nil {
+		t.Fatalf("failed to create TodoStore: %v", err)
+	}
+	t.Cleanup(func() {
+		ts.Close(ctx)
+	})
+
+	srv := httptest.NewServer(router.NewMux(ts, router.WithCollaborators(ts), router.WithEasyAuth()))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	client := srv.Client()
+	do := func(method string, path string, principal string, body string) *http.Response {
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, srv.URL+path, r)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if principal != "" {
+			req.Header.Set("X-MS-CLIENT-PRINCIPAL-ID", principal)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		t.Cleanup(func() {
+			resp.Body.Close()
+		})
+		return resp
+	}
+
+	resp := do(http.MethodPost, "/todo", "alice", `{"description": "Shared todo", "details": "", "done": false}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("want status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	item := strings.TrimPrefix(resp.Header.Get("Location"), srv.URL)
+	update := `{"description": "Shared todo", "details": "updated", "done": true}`
+
+	steps := []struct {
+		name      string
+		method    string
+		path      string
+		principal string
+		body      string
+		want      int
+	}{
+		{"anonymous_cannot_create", http.MethodPost, "/todo", "", update, http.StatusUnauthorized},
+		{"owner_can_read", http.MethodGet, item, "alice", "", http.StatusOK},
+		{"anonymous_cannot_see", http.MethodGet, item, "", "", http.StatusNotFound},
+		{"stranger_cannot_see", http.MethodGet, item, "bob", "", http.StatusNotFound},
+		{"stranger_cannot_share", http.MethodPut, item + "/collaborators/bob", "bob", `{"role": "editor"}`, http.StatusNotFound},
+		{"owner_shares_viewer", http.MethodPut, item + "/collaborators/bob", "alice", `{"role": "viewer"}`, http.StatusOK},
+		{"viewer_can_read", http.MethodGet, item, "bob", "", http.StatusOK},
+		{"viewer_can_list_collaborators", http.MethodGet, item + "/collaborators", "bob", "", http.StatusOK},
+		{"viewer_cannot_update", http.MethodPut, item, "bob", update, http.StatusForbidden},
+		{"viewer_cannot_share", http.MethodPut, item + "/collaborators/carol", "bob", `{"role": "viewer"}`, http.StatusForbidden},
+		{"owner_shares_editor", http.MethodPut, item + "/collaborators/bob", "alice", `{"role": "editor"}`, http.StatusOK},
+		{"editor_can_update", http.MethodPut, item, "bob", update, http.StatusOK},
+		{"editor_cannot_delete", http.MethodDelete, item, "bob", "", http.StatusForbidden},
+		{"stranger_cannot_update", http.MethodPut, item, "carol", update, http.StatusNotFound},
+		{"owner_revokes", http.MethodDelete, item + "/collaborators/bob", "alice", "", http.StatusNoContent},
+		{"revoked_cannot_see", http.MethodGet, item, "bob", "", http.StatusNotFound},
+		{"owner_can_delete", http.MethodDelete, item, "alice", "", http.StatusNoContent},
+	}
+	for _, s := range steps {
+		if resp := do(s.method, s.path, s.principal, s.body); resp.StatusCode != s.want {
+			t.Errorf("%s: want status code %d, got %d", s.name, s.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestShareListTodo(t *testing.T) {
+	ctx := context.Background()
+	pgContainer, err := runPostgres(ctx, "postgres:16-alpine")
+	if err != nil {
+		t.Fatalf("failed to initialize Postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Errorf("failed to terminate Postgres container: %v", err)
+		}
+	})
+
+	connStr, err := pgContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	ts, err := pg.NewStore(ctx, connStr)
+	if err != nil {
+		t.Fatalf("failed to create TodoStore: %v", err)
+	}
+	t.Cleanup(func() {
+		ts.Close(ctx)
+	})
+
+	srv := httptest.NewServer(router.NewMux(ts, router.WithLists(ts), router.WithEasyAuth()))
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	client := srv.Client()
+	do := func(method string, path string, principal string, body string) *http.Response {
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req, err := http.NewRequest(method, srv.URL+path, r)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if principal != "" {
+			req.Header.Set("X-MS-CLIENT-PRINCIPAL-ID", principal)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		t.Cleanup(func() {
+			resp.Body.Close()
+		})
+		return resp
+	}
+
+	resp := do(http.MethodPost, "/list", "alice", `{"name": "Groceries"}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("want status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	var l model.List
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		t.Fatalf("failed to decode list: %v", err)
+	}
+	list := fmt.Sprintf("/list/%d", l.Id)
+	todo := fmt.Sprintf(`{"description": "Milk", "details": "", "done": false, "listId": %d}`, l.Id)
+
+	resp = do(http.MethodPost, "/todo", "alice", todo)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("want status code %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	item := strings.TrimPrefix(resp.Header.Get("Location"), srv.URL)
+	update := `{"description": "Milk", "details": "2 litres", "done": false}`
+
+	steps := []struct {
+		name      string
+		method    string
+		path      string
+		principal string
+		body      string
+		want      int
+	}{
+		{"anonymous_cannot_create", http.MethodPost, "/list", "", `{"name": "Chores"}`, http.StatusUnauthorized},
+		{"stranger_cannot_see_list", http.MethodGet, list, "bob", "", http.StatusNotFound},
+		{"stranger_cannot_see_todo", http.MethodGet, item, "bob", "", http.StatusNotFound},
+		{"stranger_cannot_add", http.MethodPost, "/todo", "bob", todo, http.StatusUnprocessableEntity},
+		{"owner_shares_viewer", http.MethodPut, list + "/collaborators/bob", "alice", `{"role": "viewer"}`, http.StatusOK},
+		{"viewer_can_see_list", http.MethodGet, list + "/todo", "bob", "", http.StatusOK},
+		{"viewer_can_see_todo", http.MethodGet, item, "bob", "", http.StatusOK},
+		{"viewer_cannot_update", http.MethodPut, item, "bob", update, http.StatusForbidden},
+		{"viewer_cannot_add", http.MethodPost, "/todo", "bob", todo, http.StatusForbidden},
+		{"viewer_cannot_share", http.MethodPut, list + "/collaborators/carol", "bob", `{"role": "viewer"}`, http.StatusForbidden},
+		{"owner_shares_editor", http.MethodPut, list + "/collaborators/bob", "alice", `{"role": "editor"}`, http.StatusOK},
+		{"editor_can_update", http.MethodPut, item, "bob", update, http.StatusOK},
+		{"editor_can_add", http.MethodPost, "/todo", "bob", todo, http.StatusCreated},
+		{"editor_cannot_delete_list", http.MethodDelete, list, "bob", "", http.StatusForbidden},
+		{"collaborator_leaves", http.MethodDelete, list + "/collaborators/bob", "bob", "", http.StatusNoContent},
+		{"former_editor_cannot_see", http.MethodGet, item, "bob", "", http.StatusNotFound},
+		{"owner_deletes_list", http.MethodDelete, list, "alice", "", http.StatusNoContent},
+		{"todos_deleted_with_list", http.MethodGet, item, "alice", "", http.StatusNotFound},
+	}
+	for _, s := range steps {
+		if resp := do(s.method, s.path, s.principal, s.body); resp.StatusCode != s.want {
+			t.Errorf("%s: want status code %d, got %d", s.name, s.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestEncryptTodo(t *testing.T) {
+	ctx := context.Background()
+	pgContainer, err := runPostgres(ctx, "postgres:16-alpine")
+	if err != nil {
+		t.Fatalf("failed to initialize Postgres container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := pgContainer.Terminate(ctx); err != nil {
+			t.Errorf("failed to terminate Postgres container: %v", err)
+		}
+	})
+
+	connStr, err := pgContainer.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("failed to get connection string: %v", err)
+	}
+
+	k1, k2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	newStore := func(current string, keys map[string][]byte) *pg.TodoStore {
+		kr, err := envelope.NewKeyring(current, keys)
+		if err != nil {
+			t.Fatalf("failed to create keyring: %v", err)
+		}
+		ts, err := pg.NewStore(ctx, connStr, pg.WithEncryption(envelope.New(kr)))
+		if err != nil {
+			t.Fatalf("failed to create TodoStore: %v", err)
+		}
+		t.Cleanup(func() {
+			ts.Close(ctx)
+		})
+		return ts
+	}
+
+	v1 := newStore("v1", map[string][]byte{"v1": k1})
+	created, err := v1.Create(ctx, model.Todo{Description: "Encrypted", Details: "secret details"})
+	if err != nil {
+		t.Fatalf("failed to create todo: %v", err)
+	}
+	// Encrypts the seeded plaintext rows.
+	if n, err := v1.Reencrypt(ctx, 2); err != nil || n == 0 {
+		t.Fatalf("want plaintext rows to be encrypted, got %d rows, error %v", n, err)
+	}
+	if n, err := v1.Reencrypt(ctx, 2); err != nil || n != 0 {
+		t.Errorf("want no rows to re-encrypt, got %d rows, error %v", n, err)
+	}
+
+	v2 := newStore("v2", map[string][]byte{"v1": k1, "v2": k2})
+	if n, err := v2.Reencrypt(ctx, 2); err != nil || n < 2 {
+		t.Fatalf("want rows to be rewrapped, got %d rows, error %v", n, err)
+	}
+
+	retired := newStore("v2", map[string][]byte{"v2": k2})
+	got, err := retired.Find(ctx, int(created.Id))
+	if err != nil {
+		t.Fatalf("failed to find todo: %v", err)
+	}
+	if got.Details != created.Details {
+		t.Errorf("want details %q, got %q", created.Details, got.Details)
+	}
+	if _, err := retired.List(ctx, 0, 100); err != nil {
+		t.Errorf("want all todos to be readable after rotation, got %v", err)
+	}
+
+	plain, err := pg.NewStore(ctx, connStr)
+	if err != nil {
+		t.Fatalf("failed to create TodoStore: %v", err)
+	}
+	t.Cleanup(func() {
+		plain.Close(ctx)
+	})
+	if _, err := plain.Find(ctx, int(created.Id)); err == nil {
+		t.Error("want error reading encrypted details without keyring")
+	}
+
+	if n, err := retired.Decrypt(ctx, 2); err != nil || n < 2 {
+		t.Fatalf("want rows to be decrypted, got %d rows, error %v", n, err)
+	}
+	got, err = plain.Find(ctx, int(created.Id))
+	if err != nil {
+		t.Fatalf("failed to find decrypted todo: %v", err)
+	}
+	if got.Details != created.Details {
+		t.Errorf("want details %q, got %q", created.Details, got.Details)
+	}
+}
@@ -7,8 +7,15 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/breaker"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/cors"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/drain"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/health"
 	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
 )
 
@@ -221,6 +228,30 @@ func TestPostBook(t *testing.T) {
 			err:  nil,
 			want: http.StatusBadRequest,
 		},
+		{
+			name: "post_book_blank_description",
+			in: &model.Todo{
+				Description: " ",
+				Details:     "test1",
+			},
+			want: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "post_book_details_too_long",
+			in: &model.Todo{
+				Description: "test1",
+				Details:     strings.Repeat("a", model.MaxDetailsLength+1),
+			},
+			want: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "post_book_store_validation_error",
+			in: &model.Todo{
+				Description: "test1",
+			},
+			err:  &model.ValidationError{Errors: []model.FieldError{{Field: "description", Message: "test"}}},
+			want: http.StatusUnprocessableEntity,
+		},
 	}
 
 	for _, tc := range tests {
@@ -288,12 +319,30 @@ func TestPutBook(t *testing.T) {
 			err:  errors.New("test error"),
 			want: http.StatusInternalServerError,
 		},
+		{
+			name: "put_book_forbidden",
+			in: &model.Todo{
+				Id:          0,
+				Description: "test1",
+				Details:     "test1",
+				Done:        false,
+			},
+			err:  model.ErrForbidden,
+			want: http.StatusForbidden,
+		},
 		{
 			name: "put_book_invalid",
 			in:   &invalid,
 			err:  nil,
 			want: http.StatusBadRequest,
 		},
+		{
+			name: "put_book_description_control_character",
+			in: &model.Todo{
+				Description: "test\x07",
+			},
+			want: http.StatusUnprocessableEntity,
+		},
 	}
 
 	for _, tc := range tests {
@@ -339,6 +388,11 @@ func TestDeleteBook(t *testing.T) {
 			err:  model.ErrEmptyResultSet,
 			want: http.StatusNotFound,
 		},
+		{
+			name: "delete_book_forbidden",
+			err:  model.ErrForbidden,
+			want: http.StatusForbidden,
+		},
 		{
 			name: "delete_book_error",
 			err:  errors.New("test error"),
@@ -435,7 +489,7 @@ func TestReadiness(t *testing.T) {
 		{
 			name: "ready_down",
 			err:  errors.New("test error"),
-			want: http.StatusInternalServerError,
+			want: http.StatusServiceUnavailable,
 		},
 	}
 
@@ -456,3 +510,178 @@ func TestReadiness(t *testing.T) {
 		})
 	}
 }
+
+func TestStartup(t *testing.T) {
+	var err error
+	ts := &mockTodoStore{
+		pingFn: func(ctx context.Context) error {
+			return err
+		},
+	}
+	h := health.New()
+	h.Register("database", ts.Ping, health.WithCacheTTL(0))
+	mux := NewMux(ts, WithHealth(h))
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"starting", errors.New("connection refused"), http.StatusServiceUnavailable},
+		{"started", nil, http.StatusOK},
+		// Once started, later failures are left to the readiness probe.
+		{"failing_after_start", errors.New("connection refused"), http.StatusOK},
+	}
+	for _, tc := range tests {
+		err = tc.err
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz/startup", nil))
+		if w.Code != tc.want {
+			t.Errorf("%s: want status code %d, got %d", tc.name, tc.want, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz/ready", nil))
+	var body map[string]any
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Error decoding report: %v", err)
+	}
+	// Check results are only served by the admin listener.
+	if len(body) != 1 || body["status"] != string(health.Fail) {
+		t.Errorf("Want only the failed status, got %v", body)
+	}
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	ts := &mockTodoStore{
+		pingFn: func(ctx context.Context) error {
+			return nil
+		},
+	}
+	d := &drain.Drainer{}
+	mux := NewMux(ts, WithDrain(d))
+
+	for _, want := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		if want == http.StatusServiceUnavailable {
+			d.Start()
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz/ready", nil))
+		if w.Code != want {
+			t.Errorf("Want status code %d, got %d", want, w.Code)
+		}
+	}
+}
+
+func TestReadinessWithBreaker(t *testing.T) {
+	ts := &mockTodoStore{
+		findFn: func(ctx context.Context, id int) (model.Todo, error) {
+			return model.Todo{}, errors.New("connection refused")
+		},
+		pingFn: func(ctx context.Context) error {
+			return nil
+		},
+	}
+	b := breaker.NewStore(ts, breaker.New(1, time.Minute), breaker.New(1, time.Minute))
+	mux := NewMux(b, WithBreaker(b))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz/ready", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Want status code %d, got %d", http.StatusOK, w.Code)
+	}
+	var report health.Report
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil || report.Status != health.Pass {
+		t.Errorf("Want passing status, got %+v, error %v", report, err)
+	}
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/todo/1", nil))
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/todo/1", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Want status code %d with open breaker, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Want Retry-After %q, got %q", "60", got)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Want status code %d for readiness with open breaker, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	p, err := cors.New(cors.Config{AllowedOrigins: []string{"https://app.example.com"}})
+	if err != nil {
+		t.Fatalf("Fatal error creating CORS policy: %v", err)
+	}
+	// The store must not be called for preflight requests, so all functions are nil.
+	mux := NewMux(&mockTodoStore{}, WithCORS(p))
+	for _, target := range []string{"/todo", "/todo/1"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodOptions, target, nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+		mux.ServeHTTP(w, r)
+		if got := w.Result().StatusCode; got != http.StatusNoContent {
+			t.Errorf("%s: want status code %d, got %d", target, http.StatusNoContent, got)
+		}
+		if got := w.Result().Header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+			t.Errorf("%s: want Access-Control-Allow-Origin %q, got %q", target, "https://app.example.com", got)
+		}
+	}
+}
+
+func TestPostRequiresPrincipal(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal string
+		opts      []Option
+		want      int
+	}{
+		{"anonymous", "", []Option{WithEasyAuth()}, http.StatusUnauthorized},
+		{"without_easy_auth", "", nil, http.StatusCreated},
+		{"principal", "alice", []Option{WithEasyAuth()}, http.StatusCreated},
+		{"anonymous_allowed", "", []Option{WithEasyAuth(), WithAnonymous()}, http.StatusCreated},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := &mockTodoStore{
+				createFn: func(ctx context.Context, item model.Todo) (model.Todo, error) {
+					return item, nil
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/todo", bytes.NewBufferString(`{"description":"x","details":""}`))
+			r.Header.Set("Content-Type", "application/json")
+			if tc.principal != "" {
+				r.Header.Set(auth.PrincipalIDHeader, tc.principal)
+			}
+			NewMux(ts, tc.opts...).ServeHTTP(w, r)
+			if w.Code != tc.want {
+				t.Errorf("Want status code %d, got %d", tc.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestValidationErrorBody(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/todo", bytes.NewBufferString(`{"description":"","details":"x"}`))
+	r.Header.Set("Content-Type", "application/json")
+	NewMux(&mockTodoStore{}).ServeHTTP(w, r)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Want status code %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	var body model.ValidationError
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Fatal error decoding response: %v", err)
+	}
+	if len(body.Errors) != 1 || body.Errors[0].Field != "description" {
+		t.Errorf("Want a single error for description, got %+v", body.Errors)
+	}
+}
@@ -0,0 +1,90 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+type grant struct {
+	Role model.Role `json:"role"`
+}
+
+func collaboratorsHandler(cs model.CollaboratorStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := idParam(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		collaborators, err := cs.Collaborators(r.Context(), id)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("reading collaborators from store: %w", err))
+			return
+		}
+		respond(w, r, collaborators, http.StatusOK)
+	}
+}
+
+func grantHandler(cs model.CollaboratorStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
+		var g grant
+		if err := bind(r, &g); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		id, principal, err := collaboratorParams(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		c := model.Collaborator{Principal: principal, Role: g.Role}
+		if err := c.Validate(); err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// Owners can't share with themselves.
+		if p, ok := auth.FromContext(r.Context()); ok && p.ID == principal {
+			writeError(w, r, model.ErrForbidden)
+			return
+		}
+		if err := cs.Grant(r.Context(), id, c); err != nil {
+			writeError(w, r, fmt.Errorf("granting access in store: %w", err))
+			return
+		}
+		respond(w, r, c, http.StatusOK)
+	}
+}
+
+func revokeHandler(cs model.CollaboratorStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, principal, err := collaboratorParams(r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if err := cs.Revoke(r.Context(), id, principal); err != nil {
+			writeError(w, r, fmt.Errorf("revoking access in store: %w", err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func collaboratorParams(r *http.Request) (int, string, error) {
+	id, err := idParam(r)
+	if err != nil {
+		return 0, "", err
+	}
+	principal, err := url.PathUnescape(chi.URLParam(r, "principal"))
+	if err != nil {
+		return 0, "", newProblem(http.StatusBadRequest, "The principal is not properly escaped.")
+	}
+	return id, principal, nil
+}
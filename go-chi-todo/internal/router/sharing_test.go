@@ -0,0 +1,196 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/auth"
+	"github.com/joergjo/azure-containerapps-demos/go-chi-todo/internal/model"
+)
+
+type mockCollaboratorStore struct {
+	collaboratorsFn func(ctx context.Context, todoID int) ([]model.Collaborator, error)
+	grantFn         func(ctx context.Context, todoID int, c model.Collaborator) error
+	revokeFn        func(ctx context.Context, todoID int, principal string) error
+}
+
+func (m *mockCollaboratorStore) Collaborators(ctx context.Context, todoID int) ([]model.Collaborator, error) {
+	return m.collaboratorsFn(ctx, todoID)
+}
+
+func (m *mockCollaboratorStore) Grant(ctx context.Context, todoID int, c model.Collaborator) error {
+	return m.grantFn(ctx, todoID, c)
+}
+
+func (m *mockCollaboratorStore) Revoke(ctx context.Context, todoID int, principal string) error {
+	return m.revokeFn(ctx, todoID, principal)
+}
+
+func TestCollaborators(t *testing.T) {
+	tests := []struct {
+		name   string
+		result []model.Collaborator
+		err    error
+		want   int
+	}{
+		{
+			name: "collaborators",
+			result: []model.Collaborator{
+				{Principal: "alice", Role: model.RoleOwner},
+				{Principal: "bob", Role: model.RoleViewer},
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "collaborators_not_found",
+			err:  model.ErrEmptyResultSet,
+			want: http.StatusNotFound,
+		},
+		{
+			name: "collaborators_error",
+			err:  errors.New("test error"),
+			want: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := &mockCollaboratorStore{
+				collaboratorsFn: func(ctx context.Context, todoID int) ([]model.Collaborator, error) {
+					return tc.result, tc.err
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/todo/1/collaborators", nil)
+			NewMux(&mockTodoStore{}, WithCollaborators(cs), WithEasyAuth()).ServeHTTP(w, r)
+			if got := w.Result().StatusCode; got != tc.want {
+				t.Fatalf("Want status code %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGrant(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal string
+		target    string
+		body      string
+		err       error
+		want      int
+	}{
+		{
+			name:      "grant_viewer",
+			principal: "alice",
+			target:    "/todo/1/collaborators/bob",
+			body:      `{"role":"viewer"}`,
+			want:      http.StatusOK,
+		},
+		{
+			name:      "grant_editor_escaped_principal",
+			principal: "alice",
+			target:    "/todo/1/collaborators/bob%40contoso.com",
+			body:      `{"role":"editor"}`,
+			want:      http.StatusOK,
+		},
+		{
+			name:      "grant_owner_role",
+			principal: "alice",
+			target:    "/todo/1/collaborators/bob",
+			body:      `{"role":"owner"}`,
+			want:      http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "grant_anonymous",
+			target: "/todo/1/collaborators/bob",
+			body:   `{"role":"viewer"}`,
+			want:   http.StatusUnauthorized,
+		},
+		{
+			name:      "grant_self",
+			principal: "alice",
+			target:    "/todo/1/collaborators/alice",
+			body:      `{"role":"viewer"}`,
+			want:      http.StatusForbidden,
+		},
+		{
+			name:      "grant_not_owner",
+			principal: "alice",
+			target:    "/todo/1/collaborators/bob",
+			body:      `{"role":"viewer"}`,
+			err:       model.ErrForbidden,
+			want:      http.StatusForbidden,
+		},
+		{
+			name:      "grant_not_visible",
+			principal: "alice",
+			target:    "/todo/1/collaborators/bob",
+			body:      `{"role":"viewer"}`,
+			err:       model.ErrEmptyResultSet,
+			want:      http.StatusNotFound,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var granted model.Collaborator
+			cs := &mockCollaboratorStore{
+				grantFn: func(ctx context.Context, todoID int, c model.Collaborator) error {
+					granted = c
+					return tc.err
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPut, tc.target, bytes.NewBufferString(tc.body))
+			r.Header.Set("Content-Type", "application/json")
+			if tc.principal != "" {
+				r.Header.Set(auth.PrincipalIDHeader, tc.principal)
+			}
+			NewMux(&mockTodoStore{}, WithCollaborators(cs), WithEasyAuth()).ServeHTTP(w, r)
+			if got := w.Result().StatusCode; got != tc.want {
+				t.Fatalf("Want status code %d, got %d", tc.want, got)
+			}
+			if tc.want != http.StatusOK {
+				return
+			}
+			var got model.Collaborator
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("Fatal error decoding response: %v", err)
+			}
+			if got != granted {
+				t.Errorf("Want response %v, got %v", granted, got)
+			}
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "revoke", want: http.StatusNoContent},
+		{name: "revoke_forbidden", err: model.ErrForbidden, want: http.StatusForbidden},
+		{name: "revoke_not_found", err: model.ErrEmptyResultSet, want: http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := &mockCollaboratorStore{
+				revokeFn: func(ctx context.Context, todoID int, principal string) error {
+					return tc.err
+				},
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodDelete, "/todo/1/collaborators/bob", nil)
+			r.Header.Set(auth.PrincipalIDHeader, "alice")
+			NewMux(&mockTodoStore{}, WithCollaborators(cs), WithEasyAuth()).ServeHTTP(w, r)
+			if got := w.Result().StatusCode; got != tc.want {
+				t.Fatalf("Want status code %d, got %d", tc.want, got)
+			}
+		})
+	}
+}
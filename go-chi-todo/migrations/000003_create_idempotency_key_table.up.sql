@@ -0,0 +1,11 @@
+CREATE TABLE
+  public.idempotency_key (
+    key char(64) PRIMARY KEY,
+    request_hash bytea NOT NULL,
+    status_code integer NULL,
+    headers jsonb NULL,
+    body bytea NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    expires_at timestamptz NOT NULL
+  );
+CREATE INDEX idempotency_key_expires_at_idx ON public.idempotency_key (expires_at);
\ No newline at end of file
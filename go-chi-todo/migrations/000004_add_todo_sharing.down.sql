@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS public.todo_acl;
+DROP INDEX IF EXISTS public.todo_owner_idx;
+ALTER TABLE public.todo DROP COLUMN IF EXISTS owner;
\ No newline at end of file
@@ -0,0 +1,11 @@
+ALTER TABLE public.todo ADD COLUMN owner varchar(255) NULL;
+CREATE INDEX todo_owner_idx ON public.todo (owner);
+CREATE TABLE
+  public.todo_acl (
+    todo_id bigint NOT NULL REFERENCES public.todo (id) ON DELETE CASCADE,
+    principal varchar(255) NOT NULL,
+    role varchar(16) NOT NULL CHECK (role IN ('viewer', 'editor')),
+    granted_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (todo_id, principal)
+  );
+CREATE INDEX todo_acl_principal_idx ON public.todo_acl (principal);
\ No newline at end of file
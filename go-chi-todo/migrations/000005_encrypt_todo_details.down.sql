@@ -0,0 +1,17 @@
+-- Dropping the ciphertext columns would destroy encrypted details, so refuse to migrate down
+-- until reencrypt has run with TODO_REENCRYPT_DECRYPT=true.
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM public.todo WHERE details_key_version IS NOT NULL) THEN
+    RAISE EXCEPTION 'todo details are still encrypted, decrypt them with reencrypt before migrating down';
+  END IF;
+END
+$$;
+-- Encrypted idempotent responses can't be replayed without their key, so forget them.
+DELETE FROM public.idempotency_key WHERE body_key_version IS NOT NULL;
+ALTER TABLE public.idempotency_key DROP COLUMN IF EXISTS body_key_version;
+ALTER TABLE public.idempotency_key DROP COLUMN IF EXISTS body_key;
+DROP INDEX IF EXISTS public.todo_details_key_version_idx;
+ALTER TABLE public.todo DROP COLUMN IF EXISTS details_key_version;
+ALTER TABLE public.todo DROP COLUMN IF EXISTS details_key;
+ALTER TABLE public.todo DROP COLUMN IF EXISTS details_ciphertext;
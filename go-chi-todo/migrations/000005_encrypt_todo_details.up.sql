@@ -0,0 +1,8 @@
+ALTER TABLE public.todo
+  ADD COLUMN details_ciphertext bytea NULL,
+  ADD COLUMN details_key bytea NULL,
+  ADD COLUMN details_key_version varchar(64) NULL;
+CREATE INDEX todo_details_key_version_idx ON public.todo (details_key_version);
+ALTER TABLE public.idempotency_key
+  ADD COLUMN body_key bytea NULL,
+  ADD COLUMN body_key_version varchar(64) NULL;
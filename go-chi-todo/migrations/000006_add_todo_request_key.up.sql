@@ -0,0 +1,2 @@
+-- Scoped idempotency key of the request that created a todo, so a retried insert returns the same row.
+ALTER TABLE public.todo ADD COLUMN request_key char(64) NULL UNIQUE;
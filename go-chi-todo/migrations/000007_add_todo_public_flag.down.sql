@@ -0,0 +1 @@
+ALTER TABLE public.todo DROP COLUMN IF EXISTS public;
@@ -0,0 +1,3 @@
+-- Public todos are visible to and editable by everyone. Todos created before they had owners stay public.
+ALTER TABLE public.todo ADD COLUMN public boolean NOT NULL DEFAULT false;
+UPDATE public.todo SET public = true WHERE owner IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS public.todo_list_id_idx;
+ALTER TABLE public.todo DROP COLUMN IF EXISTS list_id;
+DROP TABLE IF EXISTS public.todo_list_acl;
+DROP TABLE IF EXISTS public.todo_list;
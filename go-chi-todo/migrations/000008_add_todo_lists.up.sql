@@ -0,0 +1,18 @@
+CREATE TABLE
+  public.todo_list (
+    id bigserial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    owner varchar(255) NOT NULL
+  );
+CREATE INDEX todo_list_owner_idx ON public.todo_list (owner);
+CREATE TABLE
+  public.todo_list_acl (
+    list_id bigint NOT NULL REFERENCES public.todo_list (id) ON DELETE CASCADE,
+    principal varchar(255) NOT NULL,
+    role varchar(16) NOT NULL CHECK (role IN ('viewer', 'editor')),
+    granted_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (list_id, principal)
+  );
+CREATE INDEX todo_list_acl_principal_idx ON public.todo_list_acl (principal);
+ALTER TABLE public.todo ADD COLUMN list_id bigint NULL REFERENCES public.todo_list (id) ON DELETE CASCADE;
+CREATE INDEX todo_list_id_idx ON public.todo (list_id);